@@ -0,0 +1,294 @@
+package raster
+
+import (
+	"image/color"
+	"math"
+)
+
+// Color spaces without an equivalent in image/color are represented
+// by the types in this file. Their RGBA methods return approximations
+// suitable for previews, not colorimetrically accurate values.
+
+var (
+	RGBWModel    color.Model = color.ModelFunc(rgbwModel)
+	RGBW64Model  color.Model = color.ModelFunc(rgbw64Model)
+	CMYK64Model  color.Model = color.ModelFunc(cmyk64Model)
+	KCMYcmModel  color.Model = color.ModelFunc(kcmycmModel)
+	CIELabModel  color.Model = color.ModelFunc(labModel)
+	CIEXYZModel  color.Model = color.ModelFunc(xyzModel)
+	DeviceNModel color.Model = color.ModelFunc(deviceNModel)
+)
+
+// RGBW is an additive color with an extra white component, as used
+// by ColorSpaceRGBW. W is the part of the color that the device
+// renders with its white channel; it is not added to R, G and B.
+type RGBW struct {
+	R, G, B, W uint8
+}
+
+func (c RGBW) RGBA() (r, g, b, a uint32) {
+	r = uint32(c.R)
+	r |= r << 8
+	g = uint32(c.G)
+	g |= g << 8
+	b = uint32(c.B)
+	b |= b << 8
+	return r, g, b, 0xffff
+}
+
+func rgbwModel(c color.Color) color.Color {
+	if _, ok := c.(RGBW); ok {
+		return c
+	}
+	r, g, b, _ := c.RGBA()
+	w := r
+	if g < w {
+		w = g
+	}
+	if b < w {
+		w = b
+	}
+	return RGBW{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(w >> 8)}
+}
+
+// RGBW64 is the 16-bit equivalent of RGBW.
+type RGBW64 struct {
+	R, G, B, W uint16
+}
+
+func (c RGBW64) RGBA() (r, g, b, a uint32) {
+	return uint32(c.R), uint32(c.G), uint32(c.B), 0xffff
+}
+
+func rgbw64Model(c color.Color) color.Color {
+	if _, ok := c.(RGBW64); ok {
+		return c
+	}
+	r, g, b, _ := c.RGBA()
+	w := r
+	if g < w {
+		w = g
+	}
+	if b < w {
+		w = b
+	}
+	return RGBW64{uint16(r), uint16(g), uint16(b), uint16(w)}
+}
+
+// CMYK64 is the 16-bit equivalent of color.CMYK.
+type CMYK64 struct {
+	C, M, Y, K uint16
+}
+
+func (c CMYK64) RGBA() (r, g, b, a uint32) {
+	w := 0xffff - uint32(c.K)
+	r = (0xffff - uint32(c.C)) * w / 0xffff
+	g = (0xffff - uint32(c.M)) * w / 0xffff
+	b = (0xffff - uint32(c.Y)) * w / 0xffff
+	return r, g, b, 0xffff
+}
+
+func cmyk64Model(c color.Color) color.Color {
+	if _, ok := c.(CMYK64); ok {
+		return c
+	}
+	r, g, b, _ := c.RGBA()
+	w := r
+	if g > w {
+		w = g
+	}
+	if b > w {
+		w = b
+	}
+	if w == 0 {
+		return CMYK64{0, 0, 0, 0xffff}
+	}
+	return CMYK64{
+		C: uint16((w - r) * 0xffff / w),
+		M: uint16((w - g) * 0xffff / w),
+		Y: uint16((w - b) * 0xffff / w),
+		K: uint16(0xffff - w),
+	}
+}
+
+// KCMYcm is a 1-bit color of ColorSpaceKCMYcm, which has light cyan
+// and light magenta inks in addition to CMYK. Each field reports
+// whether the ink is used. RGBA renders the light inks at half
+// strength.
+type KCMYcm struct {
+	K, C, M, Y, LC, LM bool
+}
+
+func (c KCMYcm) RGBA() (r, g, b, a uint32) {
+	ink := func(full, light bool) uint16 {
+		switch {
+		case full:
+			return 0xffff
+		case light:
+			return 0x8000
+		default:
+			return 0
+		}
+	}
+	return CMYK64{
+		C: ink(c.C, c.LC),
+		M: ink(c.M, c.LM),
+		Y: ink(c.Y, false),
+		K: ink(c.K, false),
+	}.RGBA()
+}
+
+func kcmycmModel(c color.Color) color.Color {
+	if _, ok := c.(KCMYcm); ok {
+		return c
+	}
+	cmyk := cmyk64Model(c).(CMYK64)
+	// Use the light inks for coverage between a quarter and three
+	// quarters.
+	full := func(v uint16) bool { return v >= 0xc000 }
+	light := func(v uint16) bool { return v >= 0x4000 && v < 0xc000 }
+	return KCMYcm{
+		K:  cmyk.K >= 0x8000,
+		C:  full(cmyk.C),
+		M:  full(cmyk.M),
+		Y:  cmyk.Y >= 0x8000,
+		LC: light(cmyk.C),
+		LM: light(cmyk.M),
+	}
+}
+
+// D65 reference white, used for CIE conversions.
+const (
+	d65X = 0.950456
+	d65Y = 1.0
+	d65Z = 1.088754
+)
+
+// CIEXYZ is a color in the CIE XYZ color space, relative to a D65
+// white point with Y = 1.
+type CIEXYZ struct {
+	X, Y, Z float64
+}
+
+func (c CIEXYZ) RGBA() (r, g, b, a uint32) {
+	lr := 3.240479*c.X - 1.537150*c.Y - 0.498535*c.Z
+	lg := -0.969256*c.X + 1.875992*c.Y + 0.041556*c.Z
+	lb := 0.055648*c.X - 0.204043*c.Y + 1.057311*c.Z
+	return srgbCompand(lr), srgbCompand(lg), srgbCompand(lb), 0xffff
+}
+
+func xyzModel(c color.Color) color.Color {
+	switch c := c.(type) {
+	case CIEXYZ:
+		return c
+	case CIELab:
+		return c.XYZ()
+	}
+	r, g, b, _ := c.RGBA()
+	lr, lg, lb := srgbLinear(r), srgbLinear(g), srgbLinear(b)
+	return CIEXYZ{
+		X: 0.412453*lr + 0.357580*lg + 0.180423*lb,
+		Y: 0.212671*lr + 0.715160*lg + 0.072169*lb,
+		Z: 0.019334*lr + 0.119193*lg + 0.950227*lb,
+	}
+}
+
+// CIELab is a color in the CIE L*a*b* color space, relative to a D65
+// white point. L ranges from 0 to 100, A and B from -128 to 127.
+type CIELab struct {
+	L, A, B float64
+}
+
+// XYZ converts c to the CIE XYZ color space.
+func (c CIELab) XYZ() CIEXYZ {
+	finv := func(t float64) float64 {
+		if t > 6.0/29 {
+			return t * t * t
+		}
+		return 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29)
+	}
+	fy := (c.L + 16) / 116
+	return CIEXYZ{
+		X: d65X * finv(fy+c.A/500),
+		Y: d65Y * finv(fy),
+		Z: d65Z * finv(fy-c.B/200),
+	}
+}
+
+func (c CIELab) RGBA() (r, g, b, a uint32) {
+	return c.XYZ().RGBA()
+}
+
+func labModel(c color.Color) color.Color {
+	if _, ok := c.(CIELab); ok {
+		return c
+	}
+	xyz := xyzModel(c).(CIEXYZ)
+	f := func(t float64) float64 {
+		if t > (6.0/29)*(6.0/29)*(6.0/29) {
+			return math.Cbrt(t)
+		}
+		return t/(3*(6.0/29)*(6.0/29)) + 4.0/29
+	}
+	fx, fy, fz := f(xyz.X/d65X), f(xyz.Y/d65Y), f(xyz.Z/d65Z)
+	return CIELab{
+		L: 116*fy - 16,
+		A: 500 * (fx - fy),
+		B: 200 * (fy - fz),
+	}
+}
+
+func srgbLinear(v uint32) float64 {
+	f := float64(v) / 0xffff
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func srgbCompand(f float64) uint32 {
+	if f <= 0.0031308 {
+		f *= 12.92
+	} else {
+		f = 1.055*math.Pow(f, 1/2.4) - 0.055
+	}
+	switch {
+	case f <= 0:
+		return 0
+	case f >= 1:
+		return 0xffff
+	default:
+		return uint32(f*0xffff + 0.5)
+	}
+}
+
+// DeviceN is a color in a device-dependent color space with an
+// arbitrary number of colorants, as used by ColorSpaceDevice1 through
+// ColorSpaceDeviceF and ColorSpaceICC1 through ColorSpaceICCF. Values
+// holds one value per colorant, scaled to 16 bits.
+//
+// Since the meaning of the colorants is unknown, RGBA treats them as
+// inks and returns the gray level of their average coverage.
+type DeviceN struct {
+	Values []uint16
+}
+
+func (c DeviceN) RGBA() (r, g, b, a uint32) {
+	if len(c.Values) == 0 {
+		return 0xffff, 0xffff, 0xffff, 0xffff
+	}
+	var sum uint32
+	for _, v := range c.Values {
+		sum += uint32(v)
+	}
+	y := 0xffff - sum/uint32(len(c.Values))
+	return y, y, y, 0xffff
+}
+
+func deviceNModel(c color.Color) color.Color {
+	if _, ok := c.(DeviceN); ok {
+		return c
+	}
+	y := color.Gray16Model.Convert(c).(color.Gray16).Y
+	return DeviceN{Values: []uint16{0xffff - y}}
+}
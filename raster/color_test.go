@@ -0,0 +1,249 @@
+package raster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseColorSpaces(t *testing.T) {
+	var tests = []struct {
+		space ColorSpace
+		in    []byte
+		out   []color.Color
+	}{
+		{ColorSpaceGray, []byte{0, 200}, []color.Color{color.Gray{0}, color.Gray{200}}},
+		{ColorSpacesGray, []byte{10}, []color.Color{color.Gray{10}}},
+		{ColorSpaceBlack, []byte{0, 200}, []color.Color{color.Gray{255}, color.Gray{55}}},
+		{ColorSpaceWHITE, []byte{255}, []color.Color{color.Gray{0}}},
+		{ColorSpaceGOLD, []byte{1}, []color.Color{color.Gray{254}}},
+		{ColorSpaceSILVER, []byte{2}, []color.Color{color.Gray{253}}},
+		{ColorSpaceRGB, []byte{1, 2, 3}, []color.Color{color.RGBA{1, 2, 3, 255}}},
+		{ColorSpacesRGB, []byte{1, 2, 3, 4, 5, 6}, []color.Color{color.RGBA{1, 2, 3, 255}, color.RGBA{4, 5, 6, 255}}},
+		{ColorSpaceAdobeRGB, []byte{1, 2, 3}, []color.Color{color.RGBA{1, 2, 3, 255}}},
+		{ColorSpaceRGBA, []byte{1, 2, 3, 4}, []color.Color{color.NRGBA{1, 2, 3, 4}}},
+		{ColorSpaceRGBW, []byte{1, 2, 3, 4}, []color.Color{RGBW{1, 2, 3, 4}}},
+		{ColorSpaceCMY, []byte{1, 2, 3}, []color.Color{color.CMYK{1, 2, 3, 0}}},
+		{ColorSpaceYMC, []byte{1, 2, 3}, []color.Color{color.CMYK{3, 2, 1, 0}}},
+		{ColorSpaceCMYK, []byte{1, 2, 3, 4}, []color.Color{color.CMYK{1, 2, 3, 4}}},
+		{ColorSpaceYMCK, []byte{1, 2, 3, 4}, []color.Color{color.CMYK{3, 2, 1, 4}}},
+		{ColorSpaceKCMY, []byte{1, 2, 3, 4}, []color.Color{color.CMYK{2, 3, 4, 1}}},
+		{ColorSpaceKCMYcm, []byte{1, 2, 3, 4}, []color.Color{color.CMYK{2, 3, 4, 1}}},
+		{ColorSpaceGMCK, []byte{1, 2, 3, 4}, []color.Color{color.CMYK{3, 2, 1, 4}}},
+		{ColorSpaceGMCS, []byte{1, 2, 3, 4}, []color.Color{color.CMYK{3, 2, 1, 4}}},
+		{ColorSpaceCIELab, []byte{255, 128, 0}, []color.Color{CIELab{100, 0, -128}}},
+		{ColorSpaceCIEXYZ, []byte{0, 0, 0}, []color.Color{CIEXYZ{0, 0, 0}}},
+		{ColorSpaceICC2, []byte{0, 255}, []color.Color{DeviceN{[]uint16{0, 0xffff}}}},
+		{ColorSpaceDevice3, []byte{1, 2, 3}, []color.Color{DeviceN{[]uint16{0x101, 0x202, 0x303}}}},
+	}
+
+	for _, tt := range tests {
+		p := testPage(tt.space, 8, 0, binary.BigEndian)
+		out, err := p.ParseColors(tt.in)
+		if err != nil {
+			t.Errorf("color space %d: got error %v", tt.space, err)
+			continue
+		}
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("color space %d: got %v, want %v", tt.space, out, tt.out)
+		}
+	}
+}
+
+func testPage(space ColorSpace, bpc, bpp int, bo binary.ByteOrder) *Page {
+	p := &Page{Header: &Header{}, dec: &Decoder{bo: bo}}
+	p.Header.CUPS.ColorSpace = space
+	p.Header.CUPS.BitsPerColor = bpc
+	if bpp == 0 {
+		bpp = bpc * numColors(&p.Header.CUPS)
+	}
+	p.Header.CUPS.BitsPerPixel = bpp
+	return p
+}
+
+func TestParseBitDepths(t *testing.T) {
+	var tests = []struct {
+		space ColorSpace
+		bpc   int
+		bpp   int
+		bo    binary.ByteOrder
+		in    []byte
+		out   []color.Color
+	}{
+		{ColorSpaceBlack, 1, 0, nil, []byte{0x80}, []color.Color{
+			color.Gray{0}, color.Gray{255}, color.Gray{255}, color.Gray{255},
+			color.Gray{255}, color.Gray{255}, color.Gray{255}, color.Gray{255},
+		}},
+		{ColorSpaceGray, 2, 0, nil, []byte{0x1b}, []color.Color{
+			color.Gray{0}, color.Gray{85}, color.Gray{170}, color.Gray{255},
+		}},
+		{ColorSpacesGray, 4, 0, nil, []byte{0x0f, 0x50}, []color.Color{
+			color.Gray{0}, color.Gray{255}, color.Gray{85}, color.Gray{0},
+		}},
+		{ColorSpaceCMYK, 1, 0, nil, []byte{0x9f}, []color.Color{
+			color.CMYK{255, 0, 0, 255}, color.CMYK{255, 255, 255, 255},
+		}},
+		// 1-bit RGB is padded to 4 bits per pixel
+		{ColorSpaceRGB, 1, 4, nil, []byte{0x4b}, []color.Color{
+			color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 255, 255},
+		}},
+		{ColorSpaceKCMYcm, 1, 8, nil, []byte{0x21, 0xd2}, []color.Color{
+			KCMYcm{K: true, LM: true}, KCMYcm{C: true, LC: true},
+		}},
+		{ColorSpaceCMY, 2, 8, nil, []byte{0x1b}, []color.Color{
+			color.CMYK{85, 170, 255, 0},
+		}},
+		{ColorSpaceGray, 16, 0, binary.BigEndian, []byte{0x12, 0x34}, []color.Color{color.Gray16{0x1234}}},
+		{ColorSpaceGray, 16, 0, binary.LittleEndian, []byte{0x12, 0x34}, []color.Color{color.Gray16{0x3412}}},
+		{ColorSpaceBlack, 16, 0, binary.BigEndian, []byte{0, 1}, []color.Color{color.Gray16{0xfffe}}},
+		{ColorSpaceRGB, 16, 0, binary.LittleEndian, []byte{1, 0, 2, 0, 3, 0}, []color.Color{color.RGBA64{1, 2, 3, 0xffff}}},
+		{ColorSpaceRGBA, 16, 0, binary.BigEndian, []byte{0, 1, 0, 2, 0, 3, 0, 4}, []color.Color{color.NRGBA64{1, 2, 3, 4}}},
+		{ColorSpaceRGBW, 16, 0, binary.BigEndian, []byte{0, 1, 0, 2, 0, 3, 0, 4}, []color.Color{RGBW64{1, 2, 3, 4}}},
+		{ColorSpaceKCMY, 16, 0, binary.BigEndian, []byte{0, 1, 0, 2, 0, 3, 0, 4}, []color.Color{CMYK64{2, 3, 4, 1}}},
+		{ColorSpaceCIELab, 16, 0, binary.BigEndian, []byte{0xff, 0xff, 0x80, 0, 0x80, 0}, []color.Color{CIELab{100, 0, 0}}},
+		{ColorSpaceDevice2, 16, 0, binary.LittleEndian, []byte{1, 0, 2, 0}, []color.Color{DeviceN{[]uint16{1, 2}}}},
+	}
+	for _, tt := range tests {
+		p := testPage(tt.space, tt.bpc, tt.bpp, tt.bo)
+		out, err := p.ParseColors(tt.in)
+		if err != nil {
+			t.Errorf("color space %d, %d bits: got error %v", tt.space, tt.bpc, err)
+			continue
+		}
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("color space %d, %d bits: got %v, want %v", tt.space, tt.bpc, out, tt.out)
+		}
+	}
+}
+
+func TestParseColorsInvalid(t *testing.T) {
+	p := testPage(ColorSpaceRGB, 8, 0, binary.BigEndian)
+	if _, err := p.ParseColors([]byte{1, 2}); err != ErrInvalidFormat {
+		t.Errorf("got %v for a partial pixel, want ErrInvalidFormat", err)
+	}
+	p.Header.CUPS.ColorSpace = 21
+	if _, err := p.ParseColors([]byte{1}); err != ErrUnsupported {
+		t.Errorf("got %v for an unknown color space, want ErrUnsupported", err)
+	}
+	p = testPage(ColorSpaceRGB, 3, 0, binary.BigEndian)
+	if _, err := p.ParseColors([]byte{1, 2, 3}); err != ErrUnsupported {
+		t.Errorf("got %v for 3 bits per color, want ErrUnsupported", err)
+	}
+}
+
+func TestCIEModels(t *testing.T) {
+	colors := []color.Color{
+		color.White,
+		color.Black,
+		color.RGBA{255, 0, 0, 255},
+		color.RGBA{12, 200, 99, 255},
+	}
+	for _, c := range colors {
+		for _, m := range []color.Model{CIELabModel, CIEXYZModel} {
+			r1, g1, b1, _ := c.RGBA()
+			r2, g2, b2, _ := m.Convert(c).RGBA()
+			for _, d := range []float64{float64(r1) - float64(r2), float64(g1) - float64(g2), float64(b1) - float64(b2)} {
+				if math.Abs(d) > 0x200 {
+					t.Errorf("%v didn't survive conversion to %T: got %d %d %d, want %d %d %d",
+						c, m.Convert(c), r2, g2, b2, r1, g1, b1)
+					break
+				}
+			}
+		}
+	}
+	if lab := CIELabModel.Convert(color.White).(CIELab); math.Abs(lab.L-100) > 0.01 || math.Abs(lab.A) > 0.01 || math.Abs(lab.B) > 0.01 {
+		t.Errorf("white has Lab values %v, want {100 0 0}", lab)
+	}
+}
+
+// reorder converts the chunky CMYK lines of a page to the given
+// color order.
+func reorder(h *Header, lines [][]byte, order ColorOrder) (*Header, [][]byte) {
+	const n = 4
+	bpc := h.CUPS.BitsPerColor
+	width := h.CUPS.Width
+	nh := *h
+	nh.CUPS.ColorOrder = order
+	nh.CUPS.BitsPerPixel = bpc
+	band := (width*bpc + 7) / 8
+	nh.CUPS.BytesPerLine = band
+	if order == BandedPixels {
+		nh.CUPS.BytesPerLine = band * n
+	}
+
+	out := make([][]byte, n*len(lines))
+	for i := range out {
+		out[i] = make([]byte, band)
+	}
+	for y, line := range lines {
+		for x := 0; x < width; x++ {
+			for c := 0; c < n; c++ {
+				off := (x*n + c) * bpc
+				v := (line[off/8] >> uint(8-off%8-bpc)) & (1<<uint(bpc) - 1)
+				dst := out[c*len(lines)+y]
+				if order == BandedPixels {
+					dst = out[y*n+c]
+				}
+				dst[x*bpc/8] |= v << uint(8-x*bpc%8-bpc)
+			}
+		}
+	}
+	if order == BandedPixels {
+		banded := make([][]byte, len(lines))
+		for y := range banded {
+			for c := 0; c < n; c++ {
+				banded[y] = append(banded[y], out[y*n+c]...)
+			}
+		}
+		out = banded
+	}
+	return &nh, out
+}
+
+func TestColorOrders(t *testing.T) {
+	for _, file := range []string{"gradient_chunked_cmyk_8_32", "gradient_chunked_cmyk_1_4"} {
+		f := open(file, t)
+		_, pages := decodeAll(f, t)
+		f.Close()
+		page := pages[0]
+
+		chunky := &Page{Header: page.header}
+		var want []color.Color
+		for _, line := range page.lines {
+			colors, err := chunky.ParseColors(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, colors[:page.header.CUPS.Width]...)
+		}
+
+		for _, order := range []ColorOrder{BandedPixels, PlanarPixels} {
+			h, lines := reorder(page.header, page.lines, order)
+			var buf bytes.Buffer
+			encodeAll(&buf, 2, binary.LittleEndian, []rawPage{{h, lines}}, t)
+
+			d, err := NewDecoder(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := d.NextPage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.UnreadLines() != len(lines) {
+				t.Errorf("%s, order %d: got %d lines, want %d", file, order, p.UnreadLines(), len(lines))
+			}
+			got, err := p.ReadAllColors(make([]byte, p.LineSize()))
+			if err != nil {
+				t.Errorf("%s, order %d: %v", file, order, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s, order %d: colors differ from chunky page", file, order)
+			}
+		}
+	}
+}
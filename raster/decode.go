@@ -2,10 +2,13 @@ package raster
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"image/color"
 	"io"
+	"math"
 )
 
 var (
@@ -27,8 +30,46 @@ var (
 	// ErrInvalidFormat is returned when encountering values that
 	// aren't possible in the supported versions of the format.
 	ErrInvalidFormat = errors.New("error in the format")
+
+	// ErrLimitExceeded is returned by NextPage when a page exceeds
+	// one of the limits set in DecoderOptions. The returned error
+	// wraps ErrLimitExceeded and names the limit.
+	ErrLimitExceeded = errors.New("limit exceeded")
 )
 
+// A DecodeError describes where in the stream decoding failed. It
+// is returned by NextPage and ReadLine, and by the functions built on
+// them, for errors in page headers and image data. It wraps the
+// underlying error, which can be inspected with errors.Is and
+// errors.As.
+type DecodeError struct {
+	// Page is the number of the page, counting from 1.
+	Page int
+	// Line is the line of the page's image data, counting from 0,
+	// or -1 if the error occurred in the page header.
+	Line int
+	// Offset is the position in the stream, counting from the start
+	// of the sync word. For errors in a page header, it is the
+	// position of the header, or the position at which scanning for
+	// a header started if none was found in recovery mode; for
+	// errors in image data, it is the position at which reading
+	// failed.
+	Offset int64
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Line < 0 {
+		return fmt.Sprintf("page %d header at offset %d: %v", e.Page, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("page %d, line %d at offset %d: %v", e.Page, e.Line, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
 const (
 	syncV1BE = "RaSt"
 	syncV1LE = "tSaR"
@@ -62,32 +103,227 @@ func parseMagic(b []byte) (version int, bo binary.ByteOrder, ok bool) {
 	}
 }
 
+// countingReader is a buffered reader that counts the bytes that
+// have been consumed from it. Data is read from r in chunks of
+// readBufferSize bytes, or more, if more data has been pushed back
+// with unreadBytes.
 type countingReader struct {
 	r io.Reader
 	n int
+	// buf[pos:] holds data that has been read from r but not yet
+	// consumed.
+	buf []byte
+	pos int
+	// err is the error returned by r when it was last read. It is
+	// returned once the buffer has been drained.
+	err error
+}
+
+const readBufferSize = 32 * 1024
+
+// fill reads more data into the buffer, after moving the unconsumed
+// data to its start. It reports the error encountered, if any.
+func (r *countingReader) fill() error {
+	if r.err != nil {
+		err := r.err
+		r.err = nil
+		return err
+	}
+	if r.buf == nil {
+		r.buf = make([]byte, 0, readBufferSize)
+	}
+	if r.pos > 0 {
+		n := copy(r.buf, r.buf[r.pos:])
+		r.buf = r.buf[:n]
+		r.pos = 0
+	}
+	if len(r.buf) == cap(r.buf) {
+		return nil
+	}
+	// Don't loop forever on readers that return no data.
+	for i := 0; i < 100; i++ {
+		n, err := r.r.Read(r.buf[len(r.buf):cap(r.buf)])
+		r.buf = r.buf[:len(r.buf)+n]
+		if n > 0 {
+			r.err = err
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return io.ErrNoProgress
 }
 
 func (r *countingReader) Read(b []byte) (n int, err error) {
-	n, err = r.r.Read(b)
+	if r.pos == len(r.buf) {
+		if len(b) >= readBufferSize && r.err == nil {
+			// Large reads bypass the buffer.
+			n, err = r.r.Read(b)
+			r.n += n
+			return n, err
+		}
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(b, r.buf[r.pos:])
+	r.pos += n
+	r.n += n
+	return n, nil
+}
+
+// readByte reads a single byte.
+func (r *countingReader) readByte() (byte, error) {
+	if r.pos == len(r.buf) {
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	c := r.buf[r.pos]
+	r.pos++
+	r.n++
+	return c, nil
+}
+
+// next reads the next n bytes. The returned slice is only valid
+// until the next call to a method of r. Fewer than n bytes are
+// returned only with an error.
+func (r *countingReader) next(n int) ([]byte, error) {
+	for len(r.buf)-r.pos < n {
+		if n > cap(r.buf) {
+			buf := make([]byte, len(r.buf)-r.pos, n)
+			copy(buf, r.buf[r.pos:])
+			r.buf, r.pos = buf, 0
+		}
+		if err := r.fill(); err != nil {
+			b := r.buf[r.pos:]
+			r.pos = len(r.buf)
+			r.n += len(b)
+			if err == io.EOF && len(b) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return b, err
+		}
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
 	r.n += n
-	return n, err
+	return b, nil
 }
 
+// unreadBytes pushes b back, so that it will be returned by the next
+// calls to Read.
+func (r *countingReader) unreadBytes(b []byte) {
+	buf := make([]byte, 0, len(b)+len(r.buf)-r.pos+readBufferSize)
+	buf = append(buf, b...)
+	r.buf = append(buf, r.buf[r.pos:]...)
+	r.pos = 0
+	r.n -= len(b)
+}
+
+// A Flavor identifies the dialect of a raster stream.
+type Flavor int
+
+const (
+	// FlavorCUPS is a generic CUPS raster stream.
+	FlavorCUPS Flavor = iota
+	// FlavorPWG is a PWG Raster stream as defined by PWG 5102.4: a
+	// big-endian version 2 stream whose pages have a MediaClass of
+	// "PwgRaster".
+	FlavorPWG
+	// FlavorURF is an Apple URF ("UNIRAST") stream.
+	FlavorURF
+)
+
 type Decoder struct {
 	r       *countingReader
 	bo      binary.ByteOrder
 	err     error
 	version int
+	flavor  Flavor
 	curPage *Page
+	opts    DecoderOptions
+	pages   int
+	// invalid is the error returned for a page that failed
+	// validation in strict mode or exceeded a limit. The rest of the
+	// stream can't be decoded reliably after such a page.
+	invalid error
+	// resync is set in recovery mode when the stream has to be
+	// scanned for the next page header.
+	resync  bool
+	skipped int64
+
+	urfPages int
+}
+
+// DecoderOptions configure a Decoder. The zero value is the default
+// configuration used by NewDecoder.
+//
+// The limits guard against hostile input, whose headers may claim
+// arbitrarily large pages. They are checked by NextPage before any
+// memory is allocated for a page. A limit of zero means no limit.
+// Once a limit has been exceeded, the decoder returns the same error
+// from all further calls to NextPage.
+type DecoderOptions struct {
+	// Strict causes NextPage to validate each page header with
+	// Header.Validate and to reject inconsistent pages with a
+	// *HeaderError, instead of decoding them as well as possible.
+	Strict bool
+
+	// MaxBytesPerLine limits CUPS.BytesPerLine, and thus the
+	// buffers needed to read single lines.
+	MaxBytesPerLine int
+	// MaxPageBytes limits the size of a page's image data, as
+	// returned by Page.Size. This is the amount of memory used by
+	// Page.ReadAll and by decoding planar pages.
+	MaxPageBytes int64
+	// MaxPages limits the number of pages in the stream.
+	MaxPages int
+	// MaxWidth and MaxHeight limit CUPS.Width and CUPS.Height.
+	MaxWidth  int
+	MaxHeight int
+
+	// Recover makes the decoder skip over corrupt data. When a page
+	// header is implausible, NextPage returns a *HeaderError, and the
+	// next call to NextPage scans forward for a plausible page
+	// header. Pages whose image data is truncated or corrupt are
+	// skipped the same way. See Decoder.Skipped. Errors returned by
+	// the underlying reader are returned as they are, and decoding
+	// resumes where it stopped on the next call to NextPage.
+	Recover bool
+	// FillMissingLines makes ReadLine return white lines in place
+	// of lines that are missing from a truncated page or that can't
+	// be decoded, instead of returning an error. See
+	// Page.Synthesized and Page.Err.
+	FillMissingLines bool
 }
 
+// NewDecoder returns a decoder for the raster stream in r. Besides
+// CUPS raster streams of versions 1, 2 and 3, Apple URF streams are
+// recognized as well.
+//
+// The decoder buffers its input and may read more data from r than
+// the stream consists of.
 func NewDecoder(r io.Reader) (*Decoder, error) {
-	d := &Decoder{r: &countingReader{r: r}}
+	return NewDecoderOptions(r, DecoderOptions{})
+}
+
+// NewDecoderOptions is like NewDecoder, but configures the decoder
+// with opts.
+func NewDecoderOptions(r io.Reader, opts DecoderOptions) (*Decoder, error) {
+	d := &Decoder{r: &countingReader{r: r}, opts: opts}
 	magic := make([]byte, 4)
-	_, err := io.ReadFull(r, magic)
+	_, err := io.ReadFull(d.r, magic)
 	if err != nil {
 		return nil, err
 	}
+	if string(magic) == syncURF[:4] {
+		if err := d.initURF(d.r); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
 	var ok bool
 	d.version, d.bo, ok = parseMagic(magic)
 	if !ok {
@@ -96,6 +332,20 @@ func NewDecoder(r io.Reader) (*Decoder, error) {
 	return d, nil
 }
 
+// Offset returns the number of bytes that have been consumed from the
+// stream, including the sync word. After a call to NextPage, it is the
+// position of the page's image data.
+func (d *Decoder) Offset() int64 {
+	return int64(d.r.n)
+}
+
+// Flavor returns the dialect of the stream. For CUPS raster streams,
+// it is determined by the first page and thus only known after the
+// first call to NextPage.
+func (d *Decoder) Flavor() Flavor {
+	return d.flavor
+}
+
 type Page struct {
 	Header    *Header
 	dec       *Decoder
@@ -103,6 +353,29 @@ type Page struct {
 	color     []byte
 	lineRep   int
 	linesRead int
+
+	// planes holds the entire image data of pages with PlanarPixels,
+	// once ReadLineColors has been called.
+	planes     []byte
+	planarLine int
+	// scratch holds the current line for the typed line accessors
+	// and Read.
+	scratch []byte
+
+	// err is the error that occurred while reading a line. No
+	// further lines are read from the stream after an error.
+	err         error
+	synthesized int
+
+	// number is the number of the page in the stream, counting
+	// from 1.
+	number int
+	// offset is the position of the page's image data in the
+	// stream.
+	offset int64
+
+	// rest is the remainder of the line partially returned by Read.
+	rest []byte
 }
 
 // NextPage returns the next page in the raster stream. After a call
@@ -110,61 +383,174 @@ type Page struct {
 // be used to decode image data anymore. Their header data, however,
 // remains valid.
 func (d *Decoder) NextPage() (*Page, error) {
+	return d.NextPageContext(context.Background())
+}
+
+// NextPageContext is like NextPage, but stops skipping the unread
+// lines of the previous page, or scanning for the next page in
+// recovery mode, when ctx is canceled, returning ctx.Err().
+func (d *Decoder) NextPageContext(ctx context.Context) (*Page, error) {
+	if d.invalid != nil {
+		return nil, d.invalid
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if d.curPage != nil {
-		if err := d.curPage.discard(); err != nil {
-			return nil, err
+		if err := d.curPage.discard(ctx); err != nil {
+			if err == ctx.Err() {
+				return nil, err
+			}
+			if !d.opts.Recover {
+				return nil, err
+			}
+			// The rest of the page is scanned past, even if the
+			// error is returned.
+			d.curPage = nil
+			d.resync = true
+			if !isFormatError(err) {
+				return nil, err
+			}
 		}
 	}
 	var err error
 	var h *Header
 
-	n := d.r.n
-	switch d.version {
-	case 1:
-		h, err = d.decodeV1Header()
-	case 2, 3:
-		h, err = d.decodeV2Header()
-	default:
-		// can't happen, NewDecoder rejects unknown versions
-		panic("impossible")
+	start := d.Offset()
+	fail := func(err error) error {
+		return &DecodeError{Page: d.pages + 1, Line: -1, Offset: start, Err: err}
 	}
-	if err == io.EOF && d.r.n != n {
-		return nil, io.ErrUnexpectedEOF
+	if d.resync {
+		d.resync = false
+		h, err = d.scanHeader(ctx)
+		if err == nil {
+			// Report errors at the header that was found, not where
+			// scanning started.
+			start = d.Offset() - int64(d.headerSize())
+		}
+	} else {
+		h, err = d.decodeHeader()
+		if err == io.EOF && d.Offset() != start {
+			err = io.ErrUnexpectedEOF
+		}
 	}
 	if err != nil {
-		return nil, err
+		if err != io.EOF && d.opts.Recover {
+			d.resync = true
+		}
+		if err == io.EOF || err == ctx.Err() {
+			return nil, err
+		}
+		return nil, fail(err)
+	}
+	if d.opts.Recover {
+		if errs := plausible(h); errs != nil {
+			d.resync = true
+			return nil, fail(&HeaderError{Problems: errs})
+		}
+	} else if d.opts.Strict {
+		if errs := h.Validate(); errs != nil {
+			d.invalid = fail(&HeaderError{Problems: errs})
+			return nil, d.invalid
+		}
+	}
+	if err := d.checkLimits(h); err != nil {
+		d.invalid = fail(err)
+		return nil, d.invalid
+	}
+	p, err := d.newPage(h)
+	if err != nil {
+		return nil, fail(err)
+	}
+	d.pages++
+	p.number = d.pages
+	if d.pages == 1 && isPWG(d.version, d.bo, h) {
+		d.flavor = FlavorPWG
 	}
+	d.curPage = p
+	return p, nil
+}
+
+// newPage returns a page with header h, whose image data starts at
+// the current position of the stream.
+func (d *Decoder) newPage(h *Header) (*Page, error) {
 	bpc, err := bytesPerColor(h)
 	if err != nil {
 		return nil, err
 	}
-	p := &Page{
+	return &Page{
 		Header: h,
 		dec:    d,
+		offset: d.Offset(),
 		line:   make([]byte, 0, h.CUPS.BytesPerLine),
 		color:  make([]byte, bpc),
+	}, nil
+}
+
+// checkLimits checks the header of the next page against the limits
+// in d.opts.
+func (d *Decoder) checkLimits(h *Header) error {
+	o := &d.opts
+	exceeds := func(name string, v, max int64) error {
+		if max > 0 && v > max {
+			return fmt.Errorf("%w: %s is %d, limit is %d", ErrLimitExceeded, name, v, max)
+		}
+		return nil
 	}
-	d.curPage = p
-	return p, nil
+	c := &h.CUPS
+	for _, err := range []error{
+		exceeds("number of pages", int64(d.pages+1), int64(o.MaxPages)),
+		exceeds("CUPS.Width", int64(c.Width), int64(o.MaxWidth)),
+		exceeds("CUPS.Height", int64(c.Height), int64(o.MaxHeight)),
+		exceeds("CUPS.BytesPerLine", int64(c.BytesPerLine), int64(o.MaxBytesPerLine)),
+		// A single pixel can't be larger than a line.
+		exceeds("CUPS.BitsPerPixel / 8", int64(c.BitsPerPixel/8), int64(o.MaxBytesPerLine)),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	// Compare by division, as the page size may overflow.
+	if max := o.MaxPageBytes; max > 0 && c.BytesPerLine > 0 && int64(numLines(c)) > max/int64(c.BytesPerLine) {
+		return fmt.Errorf("%w: page size is %d lines of %d bytes, limit is %d bytes", ErrLimitExceeded, numLines(c), c.BytesPerLine, max)
+	}
+	return nil
 }
 
-func (p *Page) discard() error {
-	n := p.UnreadLines()
+func (d *Decoder) decodeHeader() (*Header, error) {
+	// Forget the error of a previous header; the recovering decoder
+	// continues after it.
+	d.err = nil
+	switch d.version {
+	case 1:
+		return d.decodeV1Header()
+	case 2, 3:
+		return d.decodeV2Header()
+	case versionURF:
+		return d.decodeURFHeader()
+	default:
+		// can't happen, NewDecoder rejects unknown versions
+		panic("impossible")
+	}
+}
+
+func (p *Page) discard(ctx context.Context) error {
 	b := make([]byte, p.LineSize())
-	for i := 0; i < n; i++ {
+	for p.err == nil && p.UnreadLines() > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if err := p.ReadLine(b); err != nil {
-			if err == io.EOF {
-				return io.ErrUnexpectedEOF
-			}
 			return err
 		}
 	}
-	return nil
+	return p.err
 }
 
 // ReadLine returns the next line of pixels in the image. It returns
 // io.EOF if no more lines can be read. The buffer b must be at least
-// p.Header.CUPSBytesPerLine bytes large.
+// p.Header.CUPSBytesPerLine bytes large. If Read has returned part of
+// a line, the remainder of that line is skipped.
 func (p *Page) ReadLine(b []byte) error {
 	if len(b) < p.Header.CUPS.BytesPerLine {
 		return ErrBufferTooSmall
@@ -172,18 +558,42 @@ func (p *Page) ReadLine(b []byte) error {
 	if p.UnreadLines() == 0 {
 		return io.EOF
 	}
+	p.rest = nil
 	p.linesRead++
+	if p.err != nil {
+		if !p.dec.opts.FillMissingLines {
+			return p.err
+		}
+		p.fillLine(b)
+		return nil
+	}
+	var err error
 	switch p.dec.version {
 	case 1:
-		return p.readRawLine(b)
-	case 2:
-		return p.readV2Line(b)
+		err = p.readRawLine(b)
+	case 2, versionURF:
+		err = p.readV2Line(b)
 	case 3:
-		return p.readRawLine(b)
+		err = p.readRawLine(b)
 	default:
 		// can't happen, NewDecoder rejects unknown versions
 		panic("impossible")
 	}
+	if err != nil {
+		if err == io.EOF {
+			// The page promised more lines.
+			err = io.ErrUnexpectedEOF
+		}
+		// The position in the stream is unknown now, so don't read
+		// any further lines of this page.
+		err = &DecodeError{Page: p.number, Line: p.linesRead - 1, Offset: p.dec.Offset(), Err: err}
+		p.err = err
+		if p.dec.opts.FillMissingLines {
+			p.fillLine(b)
+			return nil
+		}
+	}
+	return err
 }
 
 // ReadLineColors reads a line and returns the color for each pixel.
@@ -191,7 +601,18 @@ func (p *Page) ReadLine(b []byte) error {
 // return more values than there are pixels in a line. b is used as
 // scratch space and must be at least p.Header.CUPSBytesPerLine bytes
 // large.
+//
+// For pages with PlanarPixels, the first call reads the remainder of
+// the page into memory, as the colors of a pixel are spread across
+// the entire page. ReadLine must not have been called on such pages.
 func (p *Page) ReadLineColors(b []byte) ([]color.Color, error) {
+	return p.readLineColors(context.Background(), b)
+}
+
+func (p *Page) readLineColors(ctx context.Context, b []byte) ([]color.Color, error) {
+	if p.Header.CUPS.ColorOrder == PlanarPixels {
+		return p.readPlanarLineColors(ctx)
+	}
 	err := p.ReadLine(b)
 	if err != nil {
 		return nil, err
@@ -206,6 +627,44 @@ func (p *Page) ReadLineColors(b []byte) ([]color.Color, error) {
 	return colors, nil
 }
 
+func (p *Page) readPlanarLineColors(ctx context.Context) ([]color.Color, error) {
+	h := &p.Header.CUPS
+	if p.planes == nil {
+		if p.linesRead != 0 {
+			return nil, ErrUnsupported
+		}
+		if p.UnreadLines() == 0 {
+			return nil, io.EOF
+		}
+		if numColors(h) == 0 {
+			return nil, ErrUnsupported
+		}
+		planes := make([]byte, p.Size())
+		if err := p.ReadAllContext(ctx, planes); err != nil {
+			return nil, err
+		}
+		p.planes = planes
+	}
+	if p.planarLine >= h.Height {
+		return nil, io.EOF
+	}
+	sep := make([][]byte, numColors(h))
+	plane := len(p.planes) / len(sep)
+	for i := range sep {
+		start := i*plane + p.planarLine*h.BytesPerLine
+		sep[i] = p.planes[start : start+h.BytesPerLine]
+	}
+	p.planarLine++
+	colors, err := p.parseSeparated(nil, sep)
+	if err != nil {
+		return nil, err
+	}
+	if len(colors) > h.Width {
+		colors = colors[:h.Width]
+	}
+	return colors, nil
+}
+
 func (p *Page) readV2Line(b []byte) (err error) {
 	defer func() {
 		if err == io.EOF {
@@ -219,8 +678,8 @@ func (p *Page) readV2Line(b []byte) (err error) {
 		return nil
 	}
 
-	var lineRep byte
-	err = binary.Read(p.dec.r, p.dec.bo, &lineRep)
+	r := p.dec.r
+	lineRep, err := r.readByte()
 	if err != nil {
 		return err
 	}
@@ -229,32 +688,38 @@ func (p *Page) readV2Line(b []byte) (err error) {
 	// first line, anyway.
 	p.lineRep = int(lineRep)
 
-	for len(p.line) < int(p.Header.CUPS.BytesPerLine) {
-		var n byte
-		err := binary.Read(p.dec.r, p.dec.bo, &n)
+	bpc := len(p.color)
+	for len(p.line) < p.Header.CUPS.BytesPerLine {
+		n, err := r.readByte()
 		if err != nil {
 			return err
 		}
+		start := len(p.line)
 		if n <= 127 {
 			// n repeating colors
-			n := int(n + 1)
-			_, err := io.ReadFull(p.dec.r, p.color)
+			c, err := r.next(bpc)
 			if err != nil {
 				return err
 			}
-
-			for i := 0; i < n; i++ {
-				p.line = append(p.line, p.color...)
+			p.line = grow(p.line, (int(n)+1)*bpc)
+			run := p.line[start:]
+			copy(run, c)
+			// Double the copied colors until the run is filled.
+			for i := bpc; i > 0 && i < len(run); i *= 2 {
+				copy(run[i:], run[:i])
+			}
+		} else if n == 128 && p.dec.version == versionURF {
+			// URF uses 128 to fill the rest of the line with white
+			white := p.white()
+			p.line = grow(p.line, p.Header.CUPS.BytesPerLine-start)
+			for i := start; i < len(p.line); i++ {
+				p.line[i] = white
 			}
 		} else {
 			// n non-repeating colors
-			n := 257 - int(n)
-			for i := 0; i < n; i++ {
-				_, err := io.ReadFull(p.dec.r, p.color)
-				if err != nil {
-					return err
-				}
-				p.line = append(p.line, p.color...)
+			p.line = grow(p.line, (257-int(n))*bpc)
+			if _, err := io.ReadFull(r, p.line[start:]); err != nil {
+				return err
 			}
 		}
 	}
@@ -262,21 +727,39 @@ func (p *Page) readV2Line(b []byte) (err error) {
 	return nil
 }
 
+// grow extends b by n bytes, reallocating it if necessary.
+func grow(b []byte, n int) []byte {
+	if len(b)+n <= cap(b) {
+		return b[:len(b)+n]
+	}
+	nb := make([]byte, len(b)+n, 2*cap(b)+n)
+	copy(nb, b)
+	return nb
+}
+
 func (p *Page) readRawLine(b []byte) error {
 	b = b[:p.Header.CUPS.BytesPerLine]
 	_, err := io.ReadFull(p.dec.r, b)
 	return err
 }
 
-// UnreadLines returns the number of unread lines in the page.
+// UnreadLines returns the number of unread lines in the page. Pages
+// with PlanarPixels consist of p.Header.CUPS.Height lines per color.
 func (p *Page) UnreadLines() int {
-	return int(p.Header.CUPS.Height) - p.linesRead
+	return numLines(&p.Header.CUPS) - p.linesRead
 }
 
 // ReadAll reads the entire page into b. If ReadLine has been called
 // previously, ReadAll will read the remainder of the page. It returns
 // io.EOF if the entire page has been read already.
 func (p *Page) ReadAll(b []byte) error {
+	return p.ReadAllContext(context.Background(), b)
+}
+
+// ReadAllContext is like ReadAll, but stops reading when ctx is
+// canceled, returning ctx.Err(). Cancellation is checked before
+// every line; the lines read so far remain in b.
+func (p *Page) ReadAllContext(ctx context.Context, b []byte) error {
 	if len(b) < p.Size() {
 		return ErrBufferTooSmall
 	}
@@ -285,6 +768,9 @@ func (p *Page) ReadAll(b []byte) error {
 		return io.EOF
 	}
 	for i := 0; i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		start := i * p.Header.CUPS.BytesPerLine
 		end := start + p.Header.CUPS.BytesPerLine
 		err := p.ReadLine(b[start:end:end])
@@ -298,22 +784,105 @@ func (p *Page) ReadAll(b []byte) error {
 	return nil
 }
 
+// Read implements io.Reader, reading the page's uncompressed image
+// data, line after line. It returns io.EOF after the last line.
+//
+// Read and ReadLine can be mixed. Every line that Read has started
+// returning counts as read, as far as UnreadLines and Size are
+// concerned. Calling ReadLine or ReadAll after Read has returned part
+// of a line skips the remainder of that line.
+func (p *Page) Read(b []byte) (n int, err error) {
+	if len(p.rest) > 0 {
+		n = copy(b, p.rest)
+		p.rest = p.rest[n:]
+		return n, nil
+	}
+	bpl := p.Header.CUPS.BytesPerLine
+	if p.UnreadLines() == 0 || bpl == 0 {
+		return 0, io.EOF
+	}
+	// Read as many whole lines as fit into b directly.
+	for len(b)-n >= bpl && p.UnreadLines() > 0 {
+		if err := p.ReadLine(b[n : n+bpl]); err != nil {
+			return n, err
+		}
+		n += bpl
+	}
+	if n > 0 || len(b) == 0 {
+		return n, nil
+	}
+	line := p.lineBuffer()
+	if err := p.ReadLine(line); err != nil {
+		return 0, err
+	}
+	n = copy(b, line)
+	p.rest = line[n:]
+	return n, nil
+}
+
+// WriteTo implements io.WriterTo, writing the remainder of the page's
+// uncompressed image data to w, like Read.
+func (p *Page) WriteTo(w io.Writer) (n int64, err error) {
+	if len(p.rest) > 0 {
+		m, err := w.Write(p.rest)
+		n += int64(m)
+		p.rest = p.rest[m:]
+		if err != nil {
+			return n, err
+		}
+	}
+	line := p.lineBuffer()
+	for p.UnreadLines() > 0 {
+		if err := p.ReadLine(line); err != nil {
+			return n, err
+		}
+		m, err := w.Write(line)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// lineBuffer returns a buffer for a single line, which is reused by
+// all callers.
+func (p *Page) lineBuffer() []byte {
+	if p.scratch == nil {
+		p.scratch = make([]byte, p.Header.CUPS.BytesPerLine)
+	}
+	return p.scratch
+}
+
 // ReadAllColors reads the page and returns the color for each pixel.
 // Unlike using ReadAll and ParseColors, this function will not
 // return more values than there are pixels in a page. b is used as
 // scratch space and must be at least p.Header.CUPSBytesPerLine bytes
 // large.
 func (p *Page) ReadAllColors(b []byte) ([]color.Color, error) {
+	return p.ReadAllColorsContext(context.Background(), b)
+}
+
+// ReadAllColorsContext is like ReadAllColors, but stops reading when
+// ctx is canceled, returning the colors read so far and ctx.Err().
+// Cancellation is checked before every line.
+func (p *Page) ReadAllColorsContext(ctx context.Context, b []byte) ([]color.Color, error) {
 	if len(b) < p.Header.CUPS.BytesPerLine {
 		return nil, ErrBufferTooSmall
 	}
 	n := p.UnreadLines()
+	if p.Header.CUPS.ColorOrder == PlanarPixels {
+		n = p.Header.CUPS.Height - p.planarLine
+	}
 	if n == 0 {
 		return nil, io.EOF
 	}
 	var out []color.Color
 	for i := 0; i < n; i++ {
-		colors, err := p.ReadLineColors(b)
+		if err := ctx.Err(); err != nil {
+			return out, err
+		}
+		colors, err := p.readLineColors(ctx, b)
 		if err == io.EOF {
 			return out, io.ErrUnexpectedEOF
 		}
@@ -416,6 +985,9 @@ func (d *Decoder) decodeV1Header() (*Header, error) {
 	h.MediaColor = d.readCString()
 	h.MediaType = d.readCString()
 	h.OutputType = d.readCString()
+	if d.err != nil {
+		return nil, d.err
+	}
 
 	// FIXME handle error
 	err := binary.Read(d.r, d.bo, &data)
@@ -423,9 +995,9 @@ func (d *Decoder) decodeV1Header() (*Header, error) {
 		return nil, err
 	}
 	h.AdvanceDistance = int(data.AdvanceDistance)
-	h.AdvanceMedia = int(data.AdvanceMedia)
+	h.AdvanceMedia = AdvanceMedia(data.AdvanceMedia)
 	h.Collate = data.Collate == 1
-	h.CutMedia = int(data.CutMedia)
+	h.CutMedia = CutMedia(data.CutMedia)
 	h.Duplex = data.Duplex == 1
 	h.HorizDPI = int(data.HorizDPI)
 	h.VertDPI = int(data.VertDPI)
@@ -434,8 +1006,8 @@ func (d *Decoder) decodeV1Header() (*Header, error) {
 	h.BoundingBox.Right = int(data.BoundingBox.Right)
 	h.BoundingBox.Top = int(data.BoundingBox.Top)
 	h.InsertSheet = data.InsertSheet == 1
-	h.Jog = int(data.Jog)
-	h.LeadingEdge = int(data.LeadingEdge)
+	h.Jog = Jog(data.Jog)
+	h.LeadingEdge = LeadingEdge(data.LeadingEdge)
 	h.MarginLeft = int(data.MarginLeft)
 	h.MarginBottom = int(data.MarginBottom)
 	h.ManualFeed = data.ManualFeed == 1
@@ -444,7 +1016,7 @@ func (d *Decoder) decodeV1Header() (*Header, error) {
 	h.MirrorPrint = data.MirrorPrint == 1
 	h.NegativePrint = data.NegativePrint == 1
 	h.NumCopies = int(data.NumCopies)
-	h.Orientation = int(data.Orientation)
+	h.Orientation = Orientation(data.Orientation)
 	h.OutputFaceUp = data.OutputFaceUp == 1
 	h.Width = int(data.Width)
 	h.Length = int(data.Length)
@@ -457,8 +1029,8 @@ func (d *Decoder) decodeV1Header() (*Header, error) {
 	h.CUPS.BitsPerColor = int(data.CUPSBitsPerColor)
 	h.CUPS.BitsPerPixel = int(data.CUPSBitsPerPixel)
 	h.CUPS.BytesPerLine = int(data.CUPSBytesPerLine)
-	h.CUPS.ColorOrder = int(data.CUPSColorOrder)
-	h.CUPS.ColorSpace = int(data.CUPSColorSpace)
+	h.CUPS.ColorOrder = ColorOrder(data.CUPSColorOrder)
+	h.CUPS.ColorSpace = ColorSpace(data.CUPSColorSpace)
 	h.CUPS.Compression = int(data.CUPSCompression)
 	h.CUPS.RowCount = int(data.CUPSRowCount)
 	h.CUPS.RowFeed = int(data.CUPSRowFeed)
@@ -479,7 +1051,6 @@ func (d *Decoder) decodeV2Header() (*Header, error) {
 		CUPSPageSize                [2]float32
 		CUPSImagingBBox             CUPSBoundingBox
 		CUPSInteger                 [16]uint32
-		CUPSReal                    [16]float32
 	}{}
 
 	err = binary.Read(d.r, d.bo, &data)
@@ -495,10 +1066,23 @@ func (d *Decoder) decodeV2Header() (*Header, error) {
 		ints[i] = int(v)
 	}
 	h.CUPS.Integer = ints
-	h.CUPS.Real = data.CUPSReal
 
+	// Real and String are read at once, as PWG Raster stores vendor
+	// data in their place.
+	b := make([]byte, pwgVendorSize)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, err
+	}
+	for i := range h.CUPS.Real {
+		h.CUPS.Real[i] = math.Float32frombits(d.bo.Uint32(b[i*4:]))
+	}
 	for i := range h.CUPS.String {
-		h.CUPS.String[i] = d.readCString()
+		h.CUPS.String[i] = cstring(b[64+i*64 : 128+i*64])
+	}
+	if isPWG(d.version, d.bo, h) {
+		if n := h.PWG().VendorLength; n > 0 && n <= len(b) {
+			h.vendorData = string(b[:n])
+		}
 	}
 	h.CUPS.MarkerType = d.readCString()
 	h.CUPS.RenderingIntent = d.readCString()
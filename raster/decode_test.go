@@ -1,10 +1,17 @@
 package raster
 
 import (
+	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
 	"testing"
+	"testing/iotest"
 )
 
 type file struct {
@@ -20,7 +27,7 @@ func (f file) Close() error {
 	return f.f.Close()
 }
 
-func open(s string, t *testing.T) io.ReadCloser {
+func open(s string, t testing.TB) io.ReadCloser {
 	f, err := os.Open("testdata/" + s + ".gz")
 	if err != nil {
 		t.Fatal(err)
@@ -49,7 +56,9 @@ func TestDecode(t *testing.T) {
 		{"gradient_chunked_k_1_1", nil, nil, size + 7*height},
 		{"gradient_chunked_k_8_8", nil, nil, size},
 		{"gradient_chunked_cmyk_8_32", nil, nil, size},
-		{"gradient_chunked_cmyk_1_4", nil, ErrUnsupported, size},
+		// Two 4-bit pixels per byte, so the last byte of each line
+		// contains one extra pixel.
+		{"gradient_chunked_cmyk_1_4", nil, nil, size + height},
 		{"garbage", ErrUnknownVersion, nil, 1e4},
 	}
 
@@ -152,8 +161,8 @@ func TestDecodeSkipTruncatedPage(t *testing.T) {
 	if err = p.ReadLine(b); err != nil {
 		t.Fatal(err)
 	}
-	err = p.discard()
-	if err != io.ErrUnexpectedEOF {
+	err = p.discard(context.Background())
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
 		t.Errorf("skipping over partially read truncated page returned %v, want io.ErrUnexpectedEOF", err)
 	}
 }
@@ -182,7 +191,7 @@ func TestDecodeTruncatedLine(t *testing.T) {
 	if err != nil && i != brokenLine {
 		t.Errorf("got read error %q after %d iterations, expected %d iterations", err, i, brokenLine)
 	}
-	if err != io.ErrUnexpectedEOF {
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
 		t.Errorf("got %v, want io.ErrUnexpectedEOF", err)
 	}
 }
@@ -201,7 +210,7 @@ func TestDecodeMissingLine(t *testing.T) {
 	}
 	b := make([]byte, p.Size())
 	err = p.ReadAll(b)
-	if err != io.ErrUnexpectedEOF {
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
 		t.Errorf("got %v, want io.ErrUnexpectedEOF", err)
 	}
 }
@@ -214,7 +223,331 @@ func TestDecodeTruncatedHeader(t *testing.T) {
 		t.Fatal(err)
 	}
 	_, err = d.NextPage()
-	if err != io.ErrUnexpectedEOF {
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
 		t.Errorf("got %q, want io.ErrUnexpectedEOF", err)
 	}
 }
+
+func TestDecodeStrict(t *testing.T) {
+	h := &Header{}
+	h.CUPS.Width = 4
+	h.CUPS.Height = 1
+	h.CUPS.ColorSpace = ColorSpaceGray
+	h.CUPS.BitsPerColor = 8
+	h.CUPS.BitsPerPixel = 8
+	h.CUPS.BytesPerLine = 8
+	buf := &bytes.Buffer{}
+	encodeAll(buf, 3, binary.BigEndian, []rawPage{{h, [][]byte{make([]byte, 8)}}}, t)
+
+	_, pages := decodeAll(bytes.NewReader(buf.Bytes()), t)
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+
+	d, err := NewDecoderOptions(bytes.NewReader(buf.Bytes()), DecoderOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.NextPage()
+	if !errors.Is(err, ErrInvalidFormat) {
+		t.Fatalf("got error %v, want ErrInvalidFormat", err)
+	}
+	var herr *HeaderError
+	if !errors.As(err, &herr) || len(herr.Problems) != 1 || herr.Problems[0].Field != "CUPS.BytesPerLine" {
+		t.Errorf("got error %v, want a HeaderError for CUPS.BytesPerLine", err)
+	}
+	if _, err2 := d.NextPage(); err2 != err {
+		t.Errorf("second call to NextPage returned %v, want %v", err2, err)
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	f := open("two_pages", t)
+	_, pages := decodeAll(f, t)
+	f.Close()
+	c := pages[0].header.CUPS
+	size := int64(c.Height * c.BytesPerLine)
+
+	tests := []struct {
+		opts  DecoderOptions
+		pages int
+	}{
+		{DecoderOptions{}, 2},
+		{DecoderOptions{MaxPages: 2, MaxWidth: c.Width, MaxHeight: c.Height, MaxBytesPerLine: c.BytesPerLine, MaxPageBytes: size}, 2},
+		{DecoderOptions{MaxPages: 1}, 1},
+		{DecoderOptions{MaxWidth: c.Width - 1}, 0},
+		{DecoderOptions{MaxHeight: c.Height - 1}, 0},
+		{DecoderOptions{MaxBytesPerLine: c.BytesPerLine - 1}, 0},
+		{DecoderOptions{MaxPageBytes: size - 1}, 0},
+	}
+	for i, tt := range tests {
+		f := open("two_pages", t)
+		d, err := NewDecoderOptions(f, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for {
+			_, err = d.NextPage()
+			if err != nil {
+				break
+			}
+			n++
+		}
+		f.Close()
+		if n != tt.pages {
+			t.Errorf("%d: decoded %d pages, want %d", i, n, tt.pages)
+		}
+		if n == 2 {
+			if err != io.EOF {
+				t.Errorf("%d: got error %v, want io.EOF", i, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%d: got error %v, want ErrLimitExceeded", i, err)
+		}
+		if _, err2 := d.NextPage(); err2 != err {
+			t.Errorf("%d: second error is %v, want %v", i, err2, err)
+		}
+	}
+}
+
+// countdownContext is canceled after its Err method has been called
+// n times.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (ctx *countdownContext) Err() error {
+	if ctx.n <= 0 {
+		return context.Canceled
+	}
+	ctx.n--
+	return nil
+}
+
+func TestDecodeContext(t *testing.T) {
+	f := open("two_pages", t)
+	defer f.Close()
+	d, err := NewDecoder(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := d.NextPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := p.UnreadLines()
+
+	b := make([]byte, p.Size())
+	ctx := &countdownContext{context.Background(), 10}
+	if err := p.ReadAllContext(ctx, b); err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if p.UnreadLines() != lines-10 {
+		t.Errorf("%d lines left after cancellation, want %d", p.UnreadLines(), lines-10)
+	}
+
+	ctx = &countdownContext{context.Background(), 5}
+	colors, err := p.ReadAllColorsContext(ctx, b)
+	if err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if len(colors) != 5*p.Header.CUPS.Width {
+		t.Errorf("got %d colors, want %d", len(colors), 5*p.Header.CUPS.Width)
+	}
+
+	// Skipping the rest of the page is canceled, too.
+	ctx = &countdownContext{context.Background(), 3}
+	if _, err := d.NextPageContext(ctx); err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if p.UnreadLines() != lines-17 {
+		t.Errorf("%d lines left after cancellation, want %d", p.UnreadLines(), lines-17)
+	}
+	p, err = d.NextPageContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.UnreadLines() != lines {
+		t.Errorf("second page has %d unread lines, want %d", p.UnreadLines(), lines)
+	}
+}
+
+// BenchmarkDecodeV2 decodes a fixture that has been recompressed as
+// a version 2 stream.
+func BenchmarkDecodeV2(b *testing.B) {
+	for _, name := range []string{"gradient_chunked_cmyk_8_32", "gradient_chunked_k_1_1"} {
+		b.Run(name, func(b *testing.B) {
+			f := open(name, b)
+			_, pages := decodeAll(f, b)
+			f.Close()
+			buf := &bytes.Buffer{}
+			encodeAll(buf, 2, binary.BigEndian, pages, b)
+			data := buf.Bytes()
+
+			p := firstPage(data, b)
+			line := make([]byte, p.LineSize())
+			b.SetBytes(int64(p.Size()))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p := firstPage(data, b)
+				for p.UnreadLines() > 0 {
+					if err := p.ReadLine(line); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeV2Literals(t *testing.T) {
+	// Random data mostly consists of literal runs, unlike the
+	// gradients.
+	_, want := decodeAll(bytes.NewReader(randomPage(ColorSpaceRGB, ChunkyPixels, 8, t)), t)
+	buf := &bytes.Buffer{}
+	encodeAll(buf, 2, binary.LittleEndian, want, t)
+	_, got := decodeAll(buf, t)
+	if len(got) != 1 || len(got[0].lines) != len(want[0].lines) {
+		t.Fatalf("decoded %d pages, want 1", len(got))
+	}
+	for i := range want[0].lines {
+		if !bytes.Equal(got[0].lines[i], want[0].lines[i]) {
+			t.Errorf("line %d is %x, want %x", i, got[0].lines[i], want[0].lines[i])
+		}
+	}
+}
+
+func TestPageReader(t *testing.T) {
+	data := readFile("two_pages", t)
+	_, pages := decodeAll(bytes.NewReader(data), t)
+	want := bytes.Join(pages[0].lines, nil)
+	bpl := pages[0].header.CUPS.BytesPerLine
+
+	if err := iotest.TestReader(firstPage(data, t), want); err != nil {
+		t.Error(err)
+	}
+
+	// Small reads, one byte at a time.
+	got, err := ioutil.ReadAll(iotest.OneByteReader(firstPage(data, t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("reading one byte at a time returned different data")
+	}
+
+	// Mixing Read and ReadLine skips the rest of the partially read
+	// line.
+	p := firstPage(data, t)
+	lines := p.UnreadLines()
+	b := make([]byte, bpl+1)
+	if n, err := p.Read(b); n != bpl || err != nil {
+		t.Fatalf("Read returned (%d, %v), want (%d, nil)", n, err, bpl)
+	}
+	if n, err := p.Read(b[:1]); n != 1 || err != nil {
+		t.Fatalf("Read returned (%d, %v), want (1, nil)", n, err)
+	}
+	if p.UnreadLines() != lines-2 {
+		t.Errorf("%d unread lines, want %d", p.UnreadLines(), lines-2)
+	}
+	if err := p.ReadLine(b); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[:bpl], pages[0].lines[2]) {
+		t.Errorf("ReadLine after Read returned the wrong line")
+	}
+
+	// WriteTo writes the remainder of the page.
+	p = firstPage(data, t)
+	if _, err := p.Read(b[:3]); err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	n, err := p.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(want)-3) || !bytes.Equal(buf.Bytes(), want[3:]) {
+		t.Errorf("WriteTo wrote %d bytes, want %d", n, len(want)-3)
+	}
+	if _, err := p.Read(b); err != io.EOF {
+		t.Errorf("Read after WriteTo returned %v, want io.EOF", err)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	data := readFile("raster_truncated", t)
+	d, err := NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := d.NextPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(4 + headerSizeV2); d.Offset() != want {
+		t.Errorf("image data starts at offset %d, want %d", d.Offset(), want)
+	}
+	err = p.ReadAll(make([]byte, p.Size()))
+	var derr *DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("got error %v, want a *DecodeError", err)
+	}
+	want := DecodeError{Page: 1, Line: 235, Offset: int64(len(data)), Err: io.ErrUnexpectedEOF}
+	if *derr != want {
+		t.Errorf("got %#v, want %#v", *derr, want)
+	}
+
+	f := open("truncated_header", t)
+	defer f.Close()
+	d, err = NewDecoder(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.NextPage()
+	if !errors.As(err, &derr) {
+		t.Fatalf("got error %v, want a *DecodeError", err)
+	}
+	want = DecodeError{Page: 1, Line: -1, Offset: 4, Err: io.ErrUnexpectedEOF}
+	if *derr != want {
+		t.Errorf("got %#v, want %#v", *derr, want)
+	}
+}
+
+func TestDecodeLimitsOverflow(t *testing.T) {
+	// The page size of this header overflows int64.
+	h := &Header{}
+	h.CUPS.Width = 1
+	h.CUPS.Height = 0xFFFFFFFF
+	h.CUPS.BitsPerColor = 8
+	h.CUPS.BitsPerPixel = 8
+	h.CUPS.BytesPerLine = 150000000
+	h.CUPS.ColorOrder = PlanarPixels
+	h.CUPS.ColorSpace = ColorSpaceDeviceF
+	var buf bytes.Buffer
+	e, err := NewEncoder(&buf, 3, binary.BigEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WritePage(h); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoderOptions(bytes.NewReader(buf.Bytes()), DecoderOptions{MaxPageBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.NextPage(); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("got error %v, want ErrLimitExceeded", err)
+	}
+
+	p := firstPage(buf.Bytes(), t)
+	if p.Size() != math.MaxInt {
+		t.Errorf("got size %d, want math.MaxInt", p.Size())
+	}
+}
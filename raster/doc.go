@@ -1,6 +1,14 @@
-// Package raster implements a decoder for the CUPS raster format. It
-// provides functions for decoding a CUPS raster stream line-wise or
-// page-wise.
+// Package raster implements a decoder and an encoder for the CUPS
+// raster format. It provides functions for decoding a CUPS raster
+// stream line-wise or page-wise, and for encoding one line-wise.
+//
+// PWG Raster (PWG 5102.4), a constrained form of version 2 streams,
+// is recognized by the decoder; see Decoder.Flavor and ValidatePWG.
+// The decoder also reads Apple URF streams, mapping their page
+// headers to Header.
+//
+// Streams that support random access can be indexed with Index, to
+// decode arbitrary pages without decoding the ones before them.
 //
 // For a list of currently supported color spaces and bit depths, see
 // the documentation of Page.ParseColors.
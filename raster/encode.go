@@ -0,0 +1,348 @@
+package raster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+var (
+	// ErrIncompletePage is returned by the Encoder when starting a
+	// new page, or closing the encoder, before all lines of the
+	// current page have been written.
+	ErrIncompletePage = errors.New("incomplete page")
+
+	// ErrTooManyLines is returned by WriteLine when all lines of the
+	// current page have already been written.
+	ErrTooManyLines = errors.New("too many lines")
+)
+
+// An Encoder writes a CUPS raster stream. Pages are started with
+// WritePage and their image data is written line by line with
+// WriteLine. Once a write to the underlying writer has failed, all
+// further calls return that error.
+type Encoder struct {
+	w       io.Writer
+	bo      binary.ByteOrder
+	version int
+	// err is the first error returned by w. The stream is corrupt
+	// after a failed write, so all further calls return it.
+	err error
+	// herr is the error encountered while encoding a page header.
+	herr error
+	buf  bytes.Buffer
+
+	h            *Header
+	bpc          int
+	linesWritten int
+	// v2 line repetition state
+	line    []byte
+	lineRep int
+	pending bool
+}
+
+// NewEncoder returns an encoder that writes a stream of the given
+// version (1, 2 or 3) and byte order to w. The sync word is written
+// immediately. Only version 2 streams are compressed.
+func NewEncoder(w io.Writer, version int, bo binary.ByteOrder) (*Encoder, error) {
+	var magic string
+	switch {
+	case version == 1 && bo == binary.BigEndian:
+		magic = syncV1BE
+	case version == 1 && bo == binary.LittleEndian:
+		magic = syncV1LE
+	case version == 2 && bo == binary.BigEndian:
+		magic = syncV2BE
+	case version == 2 && bo == binary.LittleEndian:
+		magic = syncV2LE
+	case version == 3 && bo == binary.BigEndian:
+		magic = syncV3BE
+	case version == 3 && bo == binary.LittleEndian:
+		magic = syncV3LE
+	default:
+		return nil, ErrUnknownVersion
+	}
+	if _, err := io.WriteString(w, magic); err != nil {
+		return nil, err
+	}
+	return &Encoder{w: w, bo: bo, version: version}, nil
+}
+
+// ByteOrder returns the byte order of the stream, which also applies
+// to the samples of pages with 16 bits per color.
+func (e *Encoder) ByteOrder() binary.ByteOrder {
+	return e.bo
+}
+
+// WritePage writes the header of a new page. All lines of the
+// previous page, if any, must have been written. The image data of
+// the page has to be written with exactly h.CUPS.Height calls to
+// WriteLine, or h.CUPS.Height calls per color for pages with
+// PlanarPixels.
+func (e *Encoder) WritePage(h *Header) error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.h != nil && e.linesWritten < numLines(&e.h.CUPS) {
+		return ErrIncompletePage
+	}
+	bpc, err := bytesPerColor(h)
+	if err != nil {
+		return err
+	}
+	if (e.version == 2 && bpc == 0) || h.CUPS.BytesPerLine < 0 || h.CUPS.Height < 0 {
+		return ErrInvalidFormat
+	}
+
+	e.buf.Reset()
+	e.herr = nil
+	e.encodeV1Header(h)
+	if e.version != 1 {
+		e.encodeV2Header(h)
+	}
+	if e.herr != nil {
+		return e.herr
+	}
+	if err := e.write(e.buf.Bytes()); err != nil {
+		return err
+	}
+
+	e.h = h
+	e.bpc = bpc
+	e.linesWritten = 0
+	e.line = e.line[:0]
+	e.lineRep = 0
+	e.pending = false
+	return nil
+}
+
+// WriteLine writes the next line of pixels of the current page. b
+// must be at least h.CUPS.BytesPerLine bytes large; only that many
+// bytes are used.
+func (e *Encoder) WriteLine(b []byte) error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.h == nil {
+		return ErrIncompletePage
+	}
+	if len(b) < e.h.CUPS.BytesPerLine {
+		return ErrBufferTooSmall
+	}
+	if e.linesWritten >= numLines(&e.h.CUPS) {
+		return ErrTooManyLines
+	}
+	e.linesWritten++
+	b = b[:e.h.CUPS.BytesPerLine]
+	switch e.version {
+	case 1, 3:
+		return e.write(b)
+	case 2:
+		return e.writeV2Line(b)
+	default:
+		// can't happen, NewEncoder rejects unknown versions
+		panic("impossible")
+	}
+}
+
+// Close reports ErrIncompletePage if the last page has not been
+// written completely, or the error of a previous failed write. It
+// does not close the underlying writer.
+func (e *Encoder) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.h != nil && e.linesWritten < numLines(&e.h.CUPS) {
+		return ErrIncompletePage
+	}
+	return nil
+}
+
+func (e *Encoder) writeV2Line(b []byte) error {
+	// Lines are buffered so that identical consecutive lines can be
+	// collapsed. A line is emitted once it differs from its
+	// successor, the repeat count overflows, or the page ends.
+	switch {
+	case e.pending && e.lineRep < 255 && bytes.Equal(e.line, b):
+		e.lineRep++
+	case e.pending:
+		if err := e.flushV2Line(); err != nil {
+			return err
+		}
+		fallthrough
+	default:
+		e.line = append(e.line[:0], b...)
+		e.pending = true
+	}
+	if e.linesWritten == numLines(&e.h.CUPS) {
+		return e.flushV2Line()
+	}
+	return nil
+}
+
+func (e *Encoder) flushV2Line() error {
+	e.buf.Reset()
+	e.buf.WriteByte(byte(e.lineRep))
+	e.lineRep = 0
+
+	line := e.line
+	bpc := e.bpc
+	if r := len(line) % bpc; r != 0 {
+		// The decoder reads whole colors; pad the last one.
+		line = append(line, make([]byte, bpc-r)...)
+	}
+	n := len(line) / bpc
+	color := func(i int) []byte { return line[i*bpc : (i+1)*bpc] }
+	for i := 0; i < n; {
+		run := 1
+		for i+run < n && run < 128 && bytes.Equal(color(i), color(i+run)) {
+			run++
+		}
+		if run > 1 || i+1 == n {
+			// run repeating colors
+			e.buf.WriteByte(byte(run - 1))
+			e.buf.Write(color(i))
+			i += run
+			continue
+		}
+		// Non-repeating colors, up to the start of the next run.
+		lit := 1
+		for i+lit < n && lit < 128 {
+			if i+lit+1 < n && bytes.Equal(color(i+lit), color(i+lit+1)) {
+				break
+			}
+			lit++
+		}
+		if lit == 1 {
+			e.buf.WriteByte(0)
+		} else {
+			e.buf.WriteByte(byte(257 - lit))
+		}
+		e.buf.Write(line[i*bpc : (i+lit)*bpc])
+		i += lit
+	}
+	e.pending = false
+	return e.write(e.buf.Bytes())
+}
+
+// write writes b to the underlying writer, and records the error, if
+// any.
+func (e *Encoder) write(b []byte) error {
+	if _, err := e.w.Write(b); err != nil {
+		e.err = err
+	}
+	return e.err
+}
+
+func (e *Encoder) writeCString(s string) {
+	if e.herr != nil {
+		return
+	}
+	if len(s) > 63 || bytes.IndexByte([]byte(s), 0) >= 0 {
+		e.herr = ErrInvalidFormat
+		return
+	}
+	var b [64]byte
+	copy(b[:], s)
+	e.buf.Write(b[:])
+}
+
+func (e *Encoder) writeUint(v int) {
+	var b [4]byte
+	e.bo.PutUint32(b[:], uint32(v))
+	e.buf.Write(b[:])
+}
+
+func (e *Encoder) writeFloat(v float32) {
+	var b [4]byte
+	e.bo.PutUint32(b[:], math.Float32bits(v))
+	e.buf.Write(b[:])
+}
+
+func (e *Encoder) writeBool(v bool) {
+	if v {
+		e.writeUint(1)
+	} else {
+		e.writeUint(0)
+	}
+}
+
+func (e *Encoder) encodeV1Header(h *Header) {
+	e.writeCString(h.MediaClass)
+	e.writeCString(h.MediaColor)
+	e.writeCString(h.MediaType)
+	e.writeCString(h.OutputType)
+	e.writeUint(h.AdvanceDistance)
+	e.writeUint(int(h.AdvanceMedia))
+	e.writeBool(h.Collate)
+	e.writeUint(int(h.CutMedia))
+	e.writeBool(h.Duplex)
+	e.writeUint(h.HorizDPI)
+	e.writeUint(h.VertDPI)
+	e.writeUint(h.BoundingBox.Left)
+	e.writeUint(h.BoundingBox.Bottom)
+	e.writeUint(h.BoundingBox.Right)
+	e.writeUint(h.BoundingBox.Top)
+	e.writeBool(h.InsertSheet)
+	e.writeUint(int(h.Jog))
+	e.writeUint(int(h.LeadingEdge))
+	e.writeUint(h.MarginLeft)
+	e.writeUint(h.MarginBottom)
+	e.writeBool(h.ManualFeed)
+	e.writeUint(h.MediaPosition)
+	e.writeUint(h.MediaWeight)
+	e.writeBool(h.MirrorPrint)
+	e.writeBool(h.NegativePrint)
+	e.writeUint(h.NumCopies)
+	e.writeUint(int(h.Orientation))
+	e.writeBool(h.OutputFaceUp)
+	e.writeUint(h.Width)
+	e.writeUint(h.Length)
+	e.writeBool(h.Separations)
+	e.writeBool(h.TraySwitch)
+	e.writeBool(h.Tumble)
+	e.writeUint(h.CUPS.Width)
+	e.writeUint(h.CUPS.Height)
+	e.writeUint(h.CUPS.MediaType)
+	e.writeUint(h.CUPS.BitsPerColor)
+	e.writeUint(h.CUPS.BitsPerPixel)
+	e.writeUint(h.CUPS.BytesPerLine)
+	e.writeUint(int(h.CUPS.ColorOrder))
+	e.writeUint(int(h.CUPS.ColorSpace))
+	e.writeUint(h.CUPS.Compression)
+	e.writeUint(h.CUPS.RowCount)
+	e.writeUint(h.CUPS.RowFeed)
+	e.writeUint(h.CUPS.RowStep)
+}
+
+func (e *Encoder) encodeV2Header(h *Header) {
+	e.writeUint(h.CUPS.NumColors)
+	e.writeFloat(h.CUPS.BorderlessScalingFactor)
+	e.writeFloat(h.CUPS.PageSize[0])
+	e.writeFloat(h.CUPS.PageSize[1])
+	e.writeFloat(h.CUPS.ImagingBBox.Left)
+	e.writeFloat(h.CUPS.ImagingBBox.Bottom)
+	e.writeFloat(h.CUPS.ImagingBBox.Right)
+	e.writeFloat(h.CUPS.ImagingBBox.Top)
+	for _, v := range h.CUPS.Integer {
+		e.writeUint(v)
+	}
+	if h.vendorData != "" {
+		// PWG vendor data takes the place of Real and String.
+		var b [pwgVendorSize]byte
+		copy(b[:], h.vendorData)
+		e.buf.Write(b[:])
+	} else {
+		for _, v := range h.CUPS.Real {
+			e.writeFloat(v)
+		}
+		for _, v := range h.CUPS.String {
+			e.writeCString(v)
+		}
+	}
+	e.writeCString(h.CUPS.MarkerType)
+	e.writeCString(h.CUPS.RenderingIntent)
+	e.writeCString(h.CUPS.PageSizeName)
+}
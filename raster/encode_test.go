@@ -0,0 +1,281 @@
+package raster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+type rawPage struct {
+	header *Header
+	lines  [][]byte
+}
+
+func decodeAll(r io.Reader, t testing.TB) (*Decoder, []rawPage) {
+	d, err := NewDecoder(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []rawPage
+	for {
+		p, err := d.NextPage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rp := rawPage{header: p.Header}
+		for p.UnreadLines() > 0 {
+			b := make([]byte, p.LineSize())
+			if err := p.ReadLine(b); err != nil {
+				t.Fatal(err)
+			}
+			rp.lines = append(rp.lines, b)
+		}
+		pages = append(pages, rp)
+	}
+	return d, pages
+}
+
+func encodeAll(w io.Writer, version int, bo binary.ByteOrder, pages []rawPage, t testing.TB) {
+	e, err := NewEncoder(w, version, bo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pages {
+		if err := e.WritePage(p.header); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range p.lines {
+			if err := e.WriteLine(l); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	files := []string{
+		"gradient_chunked_k_1_1",
+		"gradient_chunked_k_8_8",
+		"gradient_chunked_cmyk_8_32",
+		"gradient_chunked_cmyk_1_4",
+		"raster",
+		"two_pages",
+	}
+	for _, file := range files {
+		f := open(file, t)
+		orig, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, pages := decodeAll(bytes.NewReader(orig), t)
+
+		for _, version := range []int{1, 2, 3} {
+			for _, bo := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+				var buf bytes.Buffer
+				encodeAll(&buf, version, bo, pages, t)
+				if version == d.version && bo == d.bo && version != 2 {
+					// Uncompressed streams must be reproduced exactly.
+					if !bytes.Equal(buf.Bytes(), orig) {
+						t.Errorf("%s: re-encoded v%d %s stream differs from original", file, version, bo)
+					}
+				}
+
+				_, got := decodeAll(&buf, t)
+				if len(got) != len(pages) {
+					t.Errorf("%s: v%d %s: got %d pages, want %d", file, version, bo, len(got), len(pages))
+					continue
+				}
+				for i := range got {
+					want := pages[i]
+					if version == 1 {
+						// v1 headers don't carry the v2 fields.
+						h := *want.header
+						h.CUPS = CUPSHeader{
+							Width:        h.CUPS.Width,
+							Height:       h.CUPS.Height,
+							MediaType:    h.CUPS.MediaType,
+							BitsPerColor: h.CUPS.BitsPerColor,
+							BitsPerPixel: h.CUPS.BitsPerPixel,
+							BytesPerLine: h.CUPS.BytesPerLine,
+							ColorOrder:   h.CUPS.ColorOrder,
+							ColorSpace:   h.CUPS.ColorSpace,
+							Compression:  h.CUPS.Compression,
+							RowCount:     h.CUPS.RowCount,
+							RowFeed:      h.CUPS.RowFeed,
+							RowStep:      h.CUPS.RowStep,
+						}
+						want.header = &h
+					}
+					if !reflect.DeepEqual(got[i].header, want.header) {
+						t.Errorf("%s: v%d %s: page %d: header mismatch:\ngot  %+v\nwant %+v",
+							file, version, bo, i, got[i].header, want.header)
+					}
+					if !reflect.DeepEqual(got[i].lines, want.lines) {
+						t.Errorf("%s: v%d %s: page %d: image data mismatch", file, version, bo, i)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeV2Compression(t *testing.T) {
+	h := &Header{}
+	h.CUPS.Width = 300
+	h.CUPS.Height = 600
+	h.CUPS.BitsPerColor = 8
+	h.CUPS.BitsPerPixel = 24
+	h.CUPS.BytesPerLine = 900
+	h.CUPS.NumColors = 3
+	h.CUPS.ColorSpace = ColorSpaceRGB
+
+	line := func(y int) []byte {
+		b := make([]byte, h.CUPS.BytesPerLine)
+		for x := 0; x < h.CUPS.Width; x++ {
+			switch {
+			case y < 300:
+				// long runs of identical lines and colors
+			case x < 150:
+				b[3*x] = byte(x)
+				b[3*x+1] = byte(y)
+			default:
+				b[3*x+2] = byte(x / 3)
+			}
+		}
+		return b
+	}
+
+	var buf bytes.Buffer
+	e, err := NewEncoder(&buf, 2, binary.BigEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WritePage(h); err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < h.CUPS.Height; y++ {
+		if err := e.WriteLine(line(y)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.WriteLine(line(0)); err != ErrTooManyLines {
+		t.Errorf("got %v writing past the end of the page, want ErrTooManyLines", err)
+	}
+	if buf.Len() >= h.CUPS.BytesPerLine*h.CUPS.Height {
+		t.Errorf("compressed stream is %d bytes, not smaller than the raw data", buf.Len())
+	}
+
+	d, err := NewDecoder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := d.NextPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, p.LineSize())
+	for y := 0; y < h.CUPS.Height; y++ {
+		if err := p.ReadLine(b); err != nil {
+			t.Fatalf("line %d: %v", y, err)
+		}
+		if !bytes.Equal(b, line(y)) {
+			t.Fatalf("line %d differs after round trip", y)
+		}
+	}
+	if _, err := d.NextPage(); err != io.EOF {
+		t.Errorf("got %v after last page, want io.EOF", err)
+	}
+}
+
+func TestEncodeIncompletePage(t *testing.T) {
+	h := &Header{}
+	h.CUPS.Width = 8
+	h.CUPS.Height = 2
+	h.CUPS.BitsPerColor = 1
+	h.CUPS.BitsPerPixel = 1
+	h.CUPS.BytesPerLine = 1
+
+	e, err := NewEncoder(ioutil.Discard, 3, binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WritePage(h); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WriteLine([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WritePage(h); err != ErrIncompletePage {
+		t.Errorf("WritePage: got %v, want ErrIncompletePage", err)
+	}
+	if err := e.Close(); err != ErrIncompletePage {
+		t.Errorf("Close: got %v, want ErrIncompletePage", err)
+	}
+}
+
+// failingWriter fails a single write, once n bytes have been
+// written. Later writes succeed again.
+type failingWriter struct {
+	n   int
+	err error
+}
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	if len(b) > w.n {
+		n := w.n
+		w.n = 1 << 30
+		return n, w.err
+	}
+	w.n -= len(b)
+	return len(b), nil
+}
+
+func TestEncodeStickyError(t *testing.T) {
+	h := &Header{}
+	h.CUPS.Width = 8
+	h.CUPS.Height = 3
+	h.CUPS.BitsPerColor = 8
+	h.CUPS.BitsPerPixel = 8
+	h.CUPS.BytesPerLine = 8
+
+	for _, version := range []int{2, 3} {
+		errWrite := errors.New("disk full")
+		w := &failingWriter{n: 4 + headerSizeV2 + 4, err: errWrite}
+		e, err := NewEncoder(w, version, binary.BigEndian)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.WritePage(h); err != nil {
+			t.Fatal(err)
+		}
+		var failed bool
+		for y := 0; y < h.CUPS.Height; y++ {
+			err := e.WriteLine(bytes.Repeat([]byte{byte(y)}, 8))
+			if err == errWrite {
+				failed = true
+			} else if err != nil || failed {
+				t.Fatalf("v%d: line %d: got %v, want %v", version, y, err, errWrite)
+			}
+		}
+		if !failed {
+			t.Fatalf("v%d: no write failed", version)
+		}
+		if err := e.Close(); err != errWrite {
+			t.Errorf("v%d: Close: got %v, want %v", version, err, errWrite)
+		}
+		if err := e.WritePage(h); err != errWrite {
+			t.Errorf("v%d: WritePage: got %v, want %v", version, err, errWrite)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package raster
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+//go:generate go run gen_enum.go
+
+// The enumerated types of header fields print as the names of their
+// constants, and are encoded in JSON as those names, too. Values
+// without a name print as, for example, "ColorSpace(99)" and are
+// encoded as JSON numbers. When decoding JSON, both names and
+// numbers are accepted. The methods implementing this are generated
+// by gen_enum.go from the tables below.
+
+// enumNames maps the values of a group of constants to their names.
+type enumNames map[int]string
+
+func (e enumNames) name(typ string, v int) string {
+	if s, ok := e[v]; ok {
+		return s
+	}
+	return fmt.Sprintf("%s(%d)", typ, v)
+}
+
+func (e enumNames) marshal(v int) ([]byte, error) {
+	if s, ok := e[v]; ok {
+		return json.Marshal(s)
+	}
+	return json.Marshal(v)
+}
+
+func (e enumNames) unmarshal(typ string, b []byte, v *int) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return json.Unmarshal(b, v)
+	}
+	for n, name := range e {
+		if name == s {
+			*v = n
+			return nil
+		}
+	}
+	return fmt.Errorf("raster: unknown %s %q", typ, s)
+}
+
+var (
+	advanceNames = enumNames{
+		int(AdvanceNever):     "AdvanceNever",
+		int(AdvanceAfterFile): "AdvanceAfterFile",
+		int(AdvanceAfterJob):  "AdvanceAfterJob",
+		int(AdvanceAfterSet):  "AdvanceAfterSet",
+		int(AdvanceAfterPage): "AdvanceAfterPage",
+	}
+	cutNames = enumNames{
+		int(CutNever):     "CutNever",
+		int(CutAfterFile): "CutAfterFile",
+		int(CutAfterJob):  "CutAfterJob",
+		int(CutAfterSet):  "CutAfterSet",
+		int(CutAfterPage): "CutAfterPage",
+	}
+	jogNames = enumNames{
+		int(JogNever):     "JogNever",
+		int(JogAfterFile): "JogAfterFile",
+		int(JogAfterJob):  "JogAfterJob",
+		int(JogAfterSet):  "JogAfterSet",
+	}
+	edgeNames = enumNames{
+		int(EdgeTop):    "EdgeTop",
+		int(EdgeRight):  "EdgeRight",
+		int(EdgeBottom): "EdgeBottom",
+		int(EdgeLeft):   "EdgeLeft",
+	}
+	rotateNames = enumNames{
+		int(RotateNone):             "RotateNone",
+		int(RotateCounterClockwise): "RotateCounterClockwise",
+		int(RotateUpsideDown):       "RotateUpsideDown",
+		int(RotateClockwise):        "RotateClockwise",
+	}
+	colorOrderNames = enumNames{
+		int(ChunkyPixels): "ChunkyPixels",
+		int(BandedPixels): "BandedPixels",
+		int(PlanarPixels): "PlanarPixels",
+	}
+	colorSpaceNames = enumNames{
+		int(ColorSpaceGray):     "ColorSpaceGray",
+		int(ColorSpaceRGB):      "ColorSpaceRGB",
+		int(ColorSpaceRGBA):     "ColorSpaceRGBA",
+		int(ColorSpaceBlack):    "ColorSpaceBlack",
+		int(ColorSpaceCMY):      "ColorSpaceCMY",
+		int(ColorSpaceYMC):      "ColorSpaceYMC",
+		int(ColorSpaceCMYK):     "ColorSpaceCMYK",
+		int(ColorSpaceYMCK):     "ColorSpaceYMCK",
+		int(ColorSpaceKCMY):     "ColorSpaceKCMY",
+		int(ColorSpaceKCMYcm):   "ColorSpaceKCMYcm",
+		int(ColorSpaceGMCK):     "ColorSpaceGMCK",
+		int(ColorSpaceGMCS):     "ColorSpaceGMCS",
+		int(ColorSpaceWHITE):    "ColorSpaceWHITE",
+		int(ColorSpaceGOLD):     "ColorSpaceGOLD",
+		int(ColorSpaceSILVER):   "ColorSpaceSILVER",
+		int(ColorSpaceCIEXYZ):   "ColorSpaceCIEXYZ",
+		int(ColorSpaceCIELab):   "ColorSpaceCIELab",
+		int(ColorSpaceRGBW):     "ColorSpaceRGBW",
+		int(ColorSpacesGray):    "ColorSpacesGray",
+		int(ColorSpacesRGB):     "ColorSpacesRGB",
+		int(ColorSpaceAdobeRGB): "ColorSpaceAdobeRGB",
+		int(ColorSpaceICC1):     "ColorSpaceICC1",
+		int(ColorSpaceICC2):     "ColorSpaceICC2",
+		int(ColorSpaceICC3):     "ColorSpaceICC3",
+		int(ColorSpaceICC4):     "ColorSpaceICC4",
+		int(ColorSpaceICC5):     "ColorSpaceICC5",
+		int(ColorSpaceICC6):     "ColorSpaceICC6",
+		int(ColorSpaceICC7):     "ColorSpaceICC7",
+		int(ColorSpaceICC8):     "ColorSpaceICC8",
+		int(ColorSpaceICC9):     "ColorSpaceICC9",
+		int(ColorSpaceICCA):     "ColorSpaceICCA",
+		int(ColorSpaceICCB):     "ColorSpaceICCB",
+		int(ColorSpaceICCC):     "ColorSpaceICCC",
+		int(ColorSpaceICCD):     "ColorSpaceICCD",
+		int(ColorSpaceICCE):     "ColorSpaceICCE",
+		int(ColorSpaceICCF):     "ColorSpaceICCF",
+		int(ColorSpaceDevice1):  "ColorSpaceDevice1",
+		int(ColorSpaceDevice2):  "ColorSpaceDevice2",
+		int(ColorSpaceDevice3):  "ColorSpaceDevice3",
+		int(ColorSpaceDevice4):  "ColorSpaceDevice4",
+		int(ColorSpaceDevice5):  "ColorSpaceDevice5",
+		int(ColorSpaceDevice6):  "ColorSpaceDevice6",
+		int(ColorSpaceDevice7):  "ColorSpaceDevice7",
+		int(ColorSpaceDevice8):  "ColorSpaceDevice8",
+		int(ColorSpaceDevice9):  "ColorSpaceDevice9",
+		int(ColorSpaceDeviceA):  "ColorSpaceDeviceA",
+		int(ColorSpaceDeviceB):  "ColorSpaceDeviceB",
+		int(ColorSpaceDeviceC):  "ColorSpaceDeviceC",
+		int(ColorSpaceDeviceD):  "ColorSpaceDeviceD",
+		int(ColorSpaceDeviceE):  "ColorSpaceDeviceE",
+		int(ColorSpaceDeviceF):  "ColorSpaceDeviceF",
+	}
+)
+
+// NumColors returns the number of colors of the color space, or 0 if
+// the color space is unknown. The image data of pages may store more
+// colors per pixel; see ColorsPerPixel.
+func (v ColorSpace) NumColors() int {
+	switch v {
+	case ColorSpaceGray, ColorSpaceBlack, ColorSpacesGray,
+		ColorSpaceWHITE, ColorSpaceGOLD, ColorSpaceSILVER:
+		return 1
+	case ColorSpaceRGB, ColorSpacesRGB, ColorSpaceAdobeRGB,
+		ColorSpaceCMY, ColorSpaceYMC,
+		ColorSpaceCIEXYZ, ColorSpaceCIELab:
+		return 3
+	case ColorSpaceRGBA, ColorSpaceRGBW,
+		ColorSpaceCMYK, ColorSpaceYMCK, ColorSpaceKCMY, ColorSpaceKCMYcm,
+		ColorSpaceGMCK, ColorSpaceGMCS:
+		return 4
+	}
+	if v >= ColorSpaceICC1 && v <= ColorSpaceICCF {
+		return int(v-ColorSpaceICC1) + 1
+	}
+	if v >= ColorSpaceDevice1 && v <= ColorSpaceDeviceF {
+		return int(v-ColorSpaceDevice1) + 1
+	}
+	return 0
+}
+
+// ColorsPerPixel returns the number of colors stored per pixel in
+// image data of the color space with the given bits per color, or 0
+// if the color space is unknown. It only differs from NumColors for
+// ColorSpaceKCMYcm with 1 bit per color, which CUPS stores with 6
+// colors: black, cyan, magenta, yellow, light cyan and light
+// magenta.
+func (v ColorSpace) ColorsPerPixel(bitsPerColor int) int {
+	if v == ColorSpaceKCMYcm && bitsPerColor == 1 {
+		return 6
+	}
+	return v.NumColors()
+}
+
+// IsSubtractive reports whether the color space describes amounts of
+// ink or toner, with 0 meaning no colorant, as opposed to amounts of
+// light. This includes the Device color spaces, but not the ICC
+// color spaces, whose meaning depends on their profile.
+func (v ColorSpace) IsSubtractive() bool {
+	switch v {
+	case ColorSpaceBlack, ColorSpaceCMY, ColorSpaceYMC, ColorSpaceCMYK,
+		ColorSpaceYMCK, ColorSpaceKCMY, ColorSpaceKCMYcm,
+		ColorSpaceGMCK, ColorSpaceGMCS,
+		ColorSpaceWHITE, ColorSpaceGOLD, ColorSpaceSILVER:
+		return true
+	}
+	return v >= ColorSpaceDevice1 && v <= ColorSpaceDeviceF
+}
@@ -0,0 +1,110 @@
+package raster
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEnums(t *testing.T) {
+	strings := []struct {
+		v    interface{ String() string }
+		want string
+	}{
+		{AdvanceAfterPage, "AdvanceAfterPage"},
+		{CutAfterSet, "CutAfterSet"},
+		{JogNever, "JogNever"},
+		{EdgeLeft, "EdgeLeft"},
+		{RotateClockwise, "RotateClockwise"},
+		{PlanarPixels, "PlanarPixels"},
+		{ColorSpaceKCMY, "ColorSpaceKCMY"},
+		{ColorSpace(21), "ColorSpace(21)"},
+		{Orientation(-1), "Orientation(-1)"},
+	}
+	for _, tt := range strings {
+		if got := tt.v.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+
+	valid := []struct {
+		v    interface{ IsValid() bool }
+		want bool
+	}{
+		{AdvanceAfterPage, true},
+		{AdvanceMedia(5), false},
+		{EdgeLeft, true},
+		{LeadingEdge(4), false},
+		{ColorOrder(3), false},
+		{ColorSpaceAdobeRGB, true},
+		{ColorSpace(21), false},
+		{ColorSpaceDeviceF, true},
+		{ColorSpace(63), false},
+	}
+	for _, tt := range valid {
+		if got := tt.v.IsValid(); got != tt.want {
+			t.Errorf("%v.IsValid() = %t, want %t", tt.v, got, tt.want)
+		}
+	}
+
+	spaces := []struct {
+		cs          ColorSpace
+		n           int
+		subtractive bool
+	}{
+		{ColorSpaceGray, 1, false},
+		{ColorSpaceBlack, 1, true},
+		{ColorSpaceRGBW, 4, false},
+		{ColorSpaceYMC, 3, true},
+		{ColorSpaceKCMYcm, 4, true},
+		{ColorSpaceCIELab, 3, false},
+		{ColorSpaceICC3, 3, false},
+		{ColorSpaceDeviceA, 10, true},
+		{ColorSpace(99), 0, false},
+	}
+	for _, tt := range spaces {
+		if got := tt.cs.NumColors(); got != tt.n {
+			t.Errorf("%v.NumColors() = %d, want %d", tt.cs, got, tt.n)
+		}
+		if got := tt.cs.IsSubtractive(); got != tt.subtractive {
+			t.Errorf("%v.IsSubtractive() = %t, want %t", tt.cs, got, tt.subtractive)
+		}
+	}
+
+	perPixel := []struct {
+		cs  ColorSpace
+		bpc int
+		n   int
+	}{
+		{ColorSpaceKCMYcm, 1, 6},
+		{ColorSpaceKCMYcm, 8, 4},
+		{ColorSpaceKCMY, 1, 4},
+		{ColorSpaceRGB, 1, 3},
+		{ColorSpace(99), 1, 0},
+	}
+	for _, tt := range perPixel {
+		if got := tt.cs.ColorsPerPixel(tt.bpc); got != tt.n {
+			t.Errorf("%v.ColorsPerPixel(%d) = %d, want %d", tt.cs, tt.bpc, got, tt.n)
+		}
+	}
+}
+
+func TestEnumJSON(t *testing.T) {
+	b, err := json.Marshal([]ColorSpace{ColorSpaceSILVER, 99})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `["ColorSpaceSILVER",99]` {
+		t.Errorf("got %s", b)
+	}
+	var cs []ColorSpace
+	if err := json.Unmarshal([]byte(`["ColorSpaceRGBW",99,3]`), &cs); err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 3 || cs[0] != ColorSpaceRGBW || cs[1] != 99 || cs[2] != ColorSpaceBlack {
+		t.Errorf("got %v", cs)
+	}
+	var o Orientation
+	if err := json.Unmarshal([]byte(`"EdgeLeft"`), &o); err == nil {
+		t.Errorf("name of a different type was accepted")
+	}
+}
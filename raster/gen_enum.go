@@ -0,0 +1,63 @@
+//go:build ignore
+
+// gen_enum generates the methods of the enumerated header field
+// types, which all delegate to an enumNames table.
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"log"
+	"os"
+	"text/template"
+)
+
+var enums = []struct {
+	Type  string
+	Names string
+}{
+	{"AdvanceMedia", "advanceNames"},
+	{"CutMedia", "cutNames"},
+	{"Jog", "jogNames"},
+	{"LeadingEdge", "edgeNames"},
+	{"Orientation", "rotateNames"},
+	{"ColorOrder", "colorOrderNames"},
+	{"ColorSpace", "colorSpaceNames"},
+}
+
+var tmpl = template.Must(template.New("").Parse(`// Code generated by gen_enum.go; DO NOT EDIT.
+
+package raster
+{{range .}}
+func (v {{.Type}}) String() string {
+	return {{.Names}}.name("{{.Type}}", int(v))
+}
+
+// IsValid reports whether v is one of the defined {{.Type}} constants.
+func (v {{.Type}}) IsValid() bool {
+	_, ok := {{.Names}}[int(v)]
+	return ok
+}
+
+func (v {{.Type}}) MarshalJSON() ([]byte, error) {
+	return {{.Names}}.marshal(int(v))
+}
+
+func (v *{{.Type}}) UnmarshalJSON(b []byte) error {
+	return {{.Names}}.unmarshal("{{.Type}}", b, (*int)(v))
+}
+{{end}}`))
+
+func main() {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, enums); err != nil {
+		log.Fatal(err)
+	}
+	b, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("enum_gen.go", b, 0666); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,243 @@
+package raster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A FieldError describes a header field with an invalid value.
+type FieldError struct {
+	// Field is the name of the field, as in "CUPS.BitsPerColor".
+	Field string
+	// Value is the offending value.
+	Value interface{}
+	// Reason describes why the value is invalid.
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s = %v: %s", e.Field, e.Value, e.Reason)
+}
+
+// A HeaderError is returned by a strict or recovering Decoder when a
+// page header fails validation. It wraps ErrInvalidFormat.
+type HeaderError struct {
+	// Problems lists the fields that failed validation.
+	Problems []*FieldError
+}
+
+func (e *HeaderError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Error()
+	}
+	return "invalid page header: " + strings.Join(msgs, "; ")
+}
+
+func (e *HeaderError) Unwrap() error {
+	return ErrInvalidFormat
+}
+
+// Validate checks that the fields of h which describe the layout of
+// the raster data are consistent with each other, and returns one
+// FieldError per problem. It returns nil if h describes a page that
+// can be decoded.
+//
+// Chunky pixels must have BitsPerColor * NumColors bits, except for
+// color spaces with 3 colors and fewer than 8 bits per color, whose
+// pixels are padded to 4 colors, and for ColorSpaceKCMYcm with 1 bit
+// per color, whose 6 colors are padded to 8 bits, as CUPS does.
+// Banded and planar pixels have BitsPerPixel == BitsPerColor.
+func (h *Header) Validate() []*FieldError {
+	var errs []*FieldError
+	report := func(field string, value interface{}, reason string) {
+		errs = append(errs, &FieldError{field, value, reason})
+	}
+
+	c := &h.CUPS
+	if c.Width <= 0 {
+		report("CUPS.Width", c.Width, "must be positive")
+	}
+	if c.Height <= 0 {
+		report("CUPS.Height", c.Height, "must be positive")
+	}
+	if !c.ColorOrder.IsValid() {
+		report("CUPS.ColorOrder", c.ColorOrder, "unknown color order")
+	}
+	n := numColors(c)
+	if n == 0 {
+		report("CUPS.ColorSpace", c.ColorSpace, "unknown color space")
+	}
+	switch c.BitsPerColor {
+	case 1, 2, 4, 8, 16:
+	default:
+		report("CUPS.BitsPerColor", c.BitsPerColor, "must be 1, 2, 4, 8 or 16")
+	}
+	if n == 0 || len(errs) > 0 {
+		// The remaining checks depend on the fields checked so far.
+		return errs
+	}
+
+	if c.NumColors != 0 && c.NumColors != n {
+		report("CUPS.NumColors", c.NumColors, fmt.Sprintf("must be %d for %v", n, c.ColorSpace))
+	}
+	bpc := c.BitsPerColor
+	var lineBits int
+	switch c.ColorOrder {
+	case ChunkyPixels:
+		want := bpc * n
+		switch {
+		case n == 6:
+			want = 8
+		case n == 3 && bpc < 8 && c.BitsPerPixel == 4*bpc:
+			want = c.BitsPerPixel
+		}
+		if c.BitsPerPixel != want {
+			report("CUPS.BitsPerPixel", c.BitsPerPixel, fmt.Sprintf("must be %d", want))
+		}
+		lineBits = c.Width * c.BitsPerPixel
+	case BandedPixels:
+		if c.BitsPerPixel != bpc {
+			report("CUPS.BitsPerPixel", c.BitsPerPixel, "must equal BitsPerColor")
+		}
+		lineBits = (c.Width*bpc + 7) / 8 * 8 * n
+	case PlanarPixels:
+		if c.BitsPerPixel != bpc {
+			report("CUPS.BitsPerPixel", c.BitsPerPixel, "must equal BitsPerColor")
+		}
+		lineBits = c.Width * bpc
+	}
+	if want := (lineBits + 7) / 8; c.BytesPerLine != want {
+		report("CUPS.BytesPerLine", c.BytesPerLine, fmt.Sprintf("must be %d", want))
+	}
+	return errs
+}
+
+// String returns a multi-line, human-readable description of the
+// header, with one field per line. Enumerated values are printed
+// using the names of their constants.
+func (h Header) String() string {
+	var buf bytes.Buffer
+	p := func(name string, v interface{}) {
+		fmt.Fprintf(&buf, "%s: %v\n", name, v)
+	}
+	p("MediaClass", strconv.Quote(h.MediaClass))
+	p("MediaColor", strconv.Quote(h.MediaColor))
+	p("MediaType", strconv.Quote(h.MediaType))
+	p("OutputType", strconv.Quote(h.OutputType))
+	p("AdvanceDistance", h.AdvanceDistance)
+	p("AdvanceMedia", h.AdvanceMedia)
+	p("Collate", h.Collate)
+	p("CutMedia", h.CutMedia)
+	p("Duplex", h.Duplex)
+	p("HorizDPI", h.HorizDPI)
+	p("VertDPI", h.VertDPI)
+	p("BoundingBox", h.BoundingBox)
+	p("InsertSheet", h.InsertSheet)
+	p("Jog", h.Jog)
+	p("LeadingEdge", h.LeadingEdge)
+	p("MarginLeft", h.MarginLeft)
+	p("MarginBottom", h.MarginBottom)
+	p("ManualFeed", h.ManualFeed)
+	p("MediaPosition", h.MediaPosition)
+	p("MediaWeight", h.MediaWeight)
+	p("MirrorPrint", h.MirrorPrint)
+	p("NegativePrint", h.NegativePrint)
+	p("NumCopies", h.NumCopies)
+	p("Orientation", h.Orientation)
+	p("OutputFaceUp", h.OutputFaceUp)
+	p("Width", h.Width)
+	p("Length", h.Length)
+	p("Separations", h.Separations)
+	p("TraySwitch", h.TraySwitch)
+	p("Tumble", h.Tumble)
+	h.CUPS.format(&buf, "CUPS.")
+	return string(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+// MarshalJSON encodes h like any other struct, adding the vendor
+// data of PWG Raster pages, if any, as VendorData. This allows
+// headers decoded from JSON to be passed to an Encoder without
+// losing it.
+func (h Header) MarshalJSON() ([]byte, error) {
+	type header Header
+	return json.Marshal(struct {
+		header
+		VendorData []byte `json:",omitempty"`
+	}{header(h), []byte(h.vendorData)})
+}
+
+// UnmarshalJSON decodes headers encoded by MarshalJSON.
+func (h *Header) UnmarshalJSON(b []byte) error {
+	type header Header
+	v := struct {
+		*header
+		VendorData []byte
+	}{header: (*header)(h)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v.VendorData != nil {
+		if len(v.VendorData) > pwgVendorSize {
+			v.VendorData = v.VendorData[:pwgVendorSize]
+		}
+		h.vendorData = string(v.VendorData)
+	}
+	return nil
+}
+
+// Format implements fmt.Formatter. The verbs %v and %s print the
+// same description as Header.String does, without the "CUPS."
+// prefix. CUPSHeader cannot have a String method, as it has a field
+// of that name. Other verbs print the header's fields as usual.
+func (h CUPSHeader) Format(f fmt.State, verb rune) {
+	if verb != 'v' && verb != 's' {
+		directive := "%"
+		for _, flag := range "+-# 0" {
+			if f.Flag(int(flag)) {
+				directive += string(flag)
+			}
+		}
+		type plain CUPSHeader
+		fmt.Fprintf(f, directive+string(verb), plain(h))
+		return
+	}
+	var buf bytes.Buffer
+	h.format(&buf, "")
+	f.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+func (h CUPSHeader) format(buf *bytes.Buffer, prefix string) {
+	p := func(name string, v interface{}) {
+		fmt.Fprintf(buf, "%s%s: %v\n", prefix, name, v)
+	}
+	p("Width", h.Width)
+	p("Height", h.Height)
+	p("MediaType", h.MediaType)
+	p("BitsPerColor", h.BitsPerColor)
+	p("BitsPerPixel", h.BitsPerPixel)
+	p("BytesPerLine", h.BytesPerLine)
+	p("ColorOrder", h.ColorOrder)
+	p("ColorSpace", h.ColorSpace)
+	p("Compression", h.Compression)
+	p("RowCount", h.RowCount)
+	p("RowFeed", h.RowFeed)
+	p("RowStep", h.RowStep)
+	p("NumColors", h.NumColors)
+	p("BorderlessScalingFactor", h.BorderlessScalingFactor)
+	p("PageSize", h.PageSize)
+	p("ImagingBBox", h.ImagingBBox)
+	p("Integer", h.Integer)
+	p("Real", h.Real)
+	quoted := make([]string, len(h.String))
+	for i, s := range h.String {
+		quoted[i] = strconv.Quote(s)
+	}
+	p("String", quoted)
+	p("MarkerType", strconv.Quote(h.MarkerType))
+	p("RenderingIntent", strconv.Quote(h.RenderingIntent))
+	p("PageSizeName", strconv.Quote(h.PageSizeName))
+}
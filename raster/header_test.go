@@ -0,0 +1,186 @@
+package raster
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHeaderString(t *testing.T) {
+	f := open("gradient_chunked_cmyk_8_32", t)
+	defer f.Close()
+	d, err := NewDecoder(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := d.NextPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := *p.Header
+	h.Orientation = RotateClockwise
+	h.LeadingEdge = 42
+	s := h.String()
+	for _, want := range []string{
+		"MediaClass: \"\"\n",
+		"AdvanceMedia: AdvanceNever\n",
+		"Orientation: RotateClockwise\n",
+		"LeadingEdge: LeadingEdge(42)\n",
+		"CUPS.ColorSpace: ColorSpaceCMYK\n",
+		"CUPS.ColorOrder: ChunkyPixels\n",
+		"CUPS.BitsPerPixel: 32\n",
+		"CUPS.PageSizeName: \"A4\"",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("String() doesn't contain %q:\n%s", want, s)
+		}
+	}
+	if cs := fmt.Sprint(h.CUPS); !strings.HasPrefix(cs, "Width: 633\nHeight: 633\n") {
+		t.Errorf("fmt.Sprint(CUPSHeader) = %q, want it to start with the size", cs)
+	}
+}
+
+func TestHeaderJSON(t *testing.T) {
+	for _, file := range []string{"raster", "gradient_chunked_k_1_1"} {
+		f := open(file, t)
+		_, pages := decodeAll(f, t)
+		f.Close()
+		h := *pages[0].header
+		h.CutMedia = CutAfterJob
+		h.CUPS.ColorSpace = ColorSpaceKCMY
+		h.CUPS.ColorOrder = 7
+
+		b, err := json.Marshal(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			`"CutMedia":"CutAfterJob"`,
+			`"ColorSpace":"ColorSpaceKCMY"`,
+			`"ColorOrder":7`,
+		} {
+			if !strings.Contains(string(b), want) {
+				t.Errorf("%s: JSON doesn't contain %s: %s", file, want, b)
+			}
+		}
+
+		var got Header
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, h) {
+			t.Errorf("%s: header didn't survive JSON round trip:\ngot  %+v\nwant %+v", file, got, h)
+		}
+		b2, err := json.Marshal(&got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != string(b2) {
+			t.Errorf("%s: JSON encoding isn't stable:\n%s\n%s", file, b, b2)
+		}
+	}
+
+	var h Header
+	if err := json.Unmarshal([]byte(`{"Jog":"JogAfterSet","CUPS":{"ColorSpace":3}}`), &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Jog != JogAfterSet || h.CUPS.ColorSpace != ColorSpaceBlack {
+		t.Errorf("got Jog %d and color space %d, want %d and %d", h.Jog, h.CUPS.ColorSpace, JogAfterSet, ColorSpaceBlack)
+	}
+	if err := json.Unmarshal([]byte(`{"Jog":"JogSometimes"}`), &h); err == nil {
+		t.Errorf("unknown enum name was accepted")
+	}
+}
+
+func TestHeaderValidate(t *testing.T) {
+	files := []string{
+		"raster",
+		"two_pages",
+		"gradient_chunked_cmyk_1_4",
+		"gradient_chunked_cmyk_8_32",
+		"gradient_chunked_k_1_1",
+		"gradient_chunked_k_8_8",
+	}
+	for _, file := range files {
+		f := open(file, t)
+		_, pages := decodeAll(f, t)
+		f.Close()
+		for _, p := range pages {
+			if errs := p.header.Validate(); errs != nil {
+				t.Errorf("%s: Validate reported %v, want no errors", file, errs)
+			}
+		}
+	}
+
+	base := func() *Header {
+		h := &Header{}
+		h.CUPS.Width = 10
+		h.CUPS.Height = 2
+		h.CUPS.ColorSpace = ColorSpaceRGB
+		h.CUPS.BitsPerColor = 8
+		h.CUPS.BitsPerPixel = 24
+		h.CUPS.BytesPerLine = 30
+		h.CUPS.NumColors = 3
+		return h
+	}
+	tests := []struct {
+		modify func(h *Header)
+		fields []string
+	}{
+		{func(h *Header) {}, nil},
+		{func(h *Header) {
+			h.CUPS.BitsPerColor = 2
+			h.CUPS.BitsPerPixel = 8
+			h.CUPS.BytesPerLine = 10
+		}, nil},
+		{func(h *Header) {
+			h.CUPS.ColorOrder = BandedPixels
+			h.CUPS.BitsPerPixel = 8
+		}, nil},
+		{func(h *Header) {
+			h.CUPS.ColorOrder = PlanarPixels
+			h.CUPS.BitsPerPixel = 8
+			h.CUPS.BytesPerLine = 10
+		}, nil},
+		{func(h *Header) {
+			h.CUPS.ColorSpace = ColorSpaceKCMYcm
+			h.CUPS.NumColors = 6
+			h.CUPS.BitsPerColor = 1
+			h.CUPS.BitsPerPixel = 8
+			h.CUPS.BytesPerLine = 10
+		}, nil},
+		{func(h *Header) {
+			h.CUPS.ColorSpace = ColorSpaceKCMYcm
+			h.CUPS.NumColors = 6
+			h.CUPS.BitsPerColor = 1
+			h.CUPS.BitsPerPixel = 6
+			h.CUPS.BytesPerLine = 8
+		}, []string{"CUPS.BitsPerPixel"}},
+		{func(h *Header) {
+			h.CUPS.ColorSpace = ColorSpaceKCMYcm
+			h.CUPS.NumColors = 4
+			h.CUPS.BitsPerPixel = 32
+			h.CUPS.BytesPerLine = 40
+		}, nil},
+		{func(h *Header) { h.CUPS.BytesPerLine = 31 }, []string{"CUPS.BytesPerLine"}},
+		{func(h *Header) { h.CUPS.BitsPerPixel = 32 }, []string{"CUPS.BitsPerPixel", "CUPS.BytesPerLine"}},
+		{func(h *Header) { h.CUPS.NumColors = 4 }, []string{"CUPS.NumColors"}},
+		{func(h *Header) { h.CUPS.Width = 0 }, []string{"CUPS.Width"}},
+		{func(h *Header) { h.CUPS.BitsPerColor = 3 }, []string{"CUPS.BitsPerColor"}},
+		{func(h *Header) { h.CUPS.ColorSpace = 99 }, []string{"CUPS.ColorSpace"}},
+		{func(h *Header) { h.CUPS.ColorOrder = 3 }, []string{"CUPS.ColorOrder"}},
+	}
+	for i, tt := range tests {
+		h := base()
+		tt.modify(h)
+		var fields []string
+		for _, err := range h.Validate() {
+			fields = append(fields, err.Field)
+		}
+		if !reflect.DeepEqual(fields, tt.fields) {
+			t.Errorf("%d: Validate reported problems with %v, want %v", i, fields, tt.fields)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package image
+
+import (
+	"image"
+	"image/color"
+	"io"
+
+	"honnef.co/go/cups/raster"
+)
+
+func init() {
+	for _, magic := range []string{"RaSt", "tSaR", "RaS2", "2SaR", "RaS3", "3SaR"} {
+		image.RegisterFormat("cups-raster", magic, decode, decodeConfig)
+	}
+}
+
+// formatOptions are the options of the decoder used by image.Decode
+// and image.DecodeConfig. The limits are documented in the package
+// documentation.
+var formatOptions = raster.DecoderOptions{
+	MaxWidth:     1 << 16,
+	MaxHeight:    1 << 16,
+	MaxPageBytes: 1 << 30,
+}
+
+// firstPage returns the first page of the stream in r.
+func firstPage(r io.Reader) (*raster.Page, error) {
+	d, err := raster.NewDecoderOptions(r, formatOptions)
+	if err != nil {
+		return nil, err
+	}
+	p, err := d.NextPage()
+	if err == io.EOF {
+		// A stream without pages has no image.
+		err = io.ErrUnexpectedEOF
+	}
+	return p, err
+}
+
+func decode(r io.Reader) (image.Image, error) {
+	p, err := firstPage(r)
+	if err != nil {
+		return nil, err
+	}
+	return Image(p)
+}
+
+// decodeConfig only reads the page header, none of the image data.
+func decodeConfig(r io.Reader) (image.Config, error) {
+	p, err := firstPage(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: colorModel(p),
+		Width:      p.Header.CUPS.Width,
+		Height:     p.Header.CUPS.Height,
+	}, nil
+}
+
+// colorModel returns the color model of the image that Image returns
+// for p.
+func colorModel(p *raster.Page) color.Model {
+	img, _ := view(p, nil, image.Rectangle{})
+	if img == nil {
+		img, _ = newConverter(p, image.Rectangle{})
+	}
+	return img.ColorModel()
+}
@@ -0,0 +1,140 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"testing"
+
+	"honnef.co/go/cups/raster"
+)
+
+func TestFormat(t *testing.T) {
+	const (
+		headerSizeV1 = 4*64 + 41*4
+		headerSizeV2 = headerSizeV1 + 40*4 + 19*64
+	)
+	type header struct {
+		cs       raster.ColorSpace
+		bpc, bpp int
+	}
+	headers := []header{
+		{raster.ColorSpaceGray, 8, 8},
+		{raster.ColorSpaceCMYK, 2, 8},
+		{raster.ColorSpaceRGB, 16, 48},
+	}
+	streams := []struct {
+		version int
+		bo      binary.ByteOrder
+		magic   string
+	}{
+		{1, binary.BigEndian, "RaSt"},
+		{1, binary.LittleEndian, "tSaR"},
+		{2, binary.BigEndian, "RaS2"},
+		{2, binary.LittleEndian, "2SaR"},
+		{3, binary.BigEndian, "RaS3"},
+		{3, binary.LittleEndian, "3SaR"},
+	}
+	for _, hh := range headers {
+		h := &raster.Header{HorizDPI: 72, VertDPI: 72}
+		h.CUPS.Width = 3
+		h.CUPS.Height = 2
+		h.CUPS.ColorSpace = hh.cs
+		h.CUPS.BitsPerColor = hh.bpc
+		h.CUPS.BitsPerPixel = hh.bpp
+		h.CUPS.BytesPerLine = (3*hh.bpp + 7) / 8
+		lines := make([][]byte, 2)
+		for i := range lines {
+			lines[i] = make([]byte, h.CUPS.BytesPerLine)
+			for j := range lines[i] {
+				lines[i][j] = uint8(i*50 + j*17)
+			}
+		}
+		for _, st := range streams {
+			data := encodePage(h, lines, st.version, st.bo, t)
+			if string(data[:4]) != st.magic {
+				t.Fatalf("stream starts with %q, want %q", data[:4], st.magic)
+			}
+			name := st.magic + " " + hh.cs.String()
+
+			img, format, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			if format != "cups-raster" {
+				t.Errorf("%s: got format %q, want cups-raster", name, format)
+			}
+			want, err := Image(decodePage(data, t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Bounds() != want.Bounds() {
+				t.Errorf("%s: got bounds %v, want %v", name, img.Bounds(), want.Bounds())
+			}
+			for y := 0; y < 2; y++ {
+				for x := 0; x < 3; x++ {
+					if img.At(x, y) != want.At(x, y) {
+						t.Errorf("%s: pixel (%d, %d) is %v, want %v", name, x, y, img.At(x, y), want.At(x, y))
+					}
+				}
+			}
+
+			// DecodeConfig only needs the header: it succeeds on a
+			// stream that ends after it.
+			size := 4 + headerSizeV2
+			if st.version == 1 {
+				size = 4 + headerSizeV1
+			}
+			header := data[:size]
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(header))
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			if format != "cups-raster" {
+				t.Errorf("%s: got format %q, want cups-raster", name, format)
+			}
+			if cfg.Width != 3 || cfg.Height != 2 || cfg.ColorModel != img.ColorModel() {
+				t.Errorf("%s: got config %v, want 3x2 with the image's color model", name, cfg)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(header)); err == nil {
+				t.Errorf("%s: decoding a stream without image data succeeded", name)
+			}
+		}
+	}
+
+	// Streams without pages have no image.
+	if _, _, err := image.DecodeConfig(bytes.NewReader([]byte("RaS3"))); err == nil {
+		t.Errorf("DecodeConfig of a stream without pages succeeded")
+	}
+}
+
+func TestFormatLimits(t *testing.T) {
+	for _, size := range []image.Point{{1<<16 + 1, 1}, {1, 1<<16 + 1}, {1 << 16, 1 << 16}} {
+		h := &raster.Header{HorizDPI: 72, VertDPI: 72}
+		h.CUPS.Width = size.X
+		h.CUPS.Height = size.Y
+		h.CUPS.ColorSpace = raster.ColorSpaceRGB
+		h.CUPS.BitsPerColor = 8
+		h.CUPS.BitsPerPixel = 24
+		h.CUPS.BytesPerLine = 3 * size.X
+		var buf bytes.Buffer
+		e, err := raster.NewEncoder(&buf, 3, binary.BigEndian)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.WritePage(h); err != nil {
+			t.Fatal(err)
+		}
+		// Only the header is written; the limits are checked before
+		// any image data is read.
+		if _, _, err := image.DecodeConfig(bytes.NewReader(buf.Bytes())); !errors.Is(err, raster.ErrLimitExceeded) {
+			t.Errorf("DecodeConfig of a %v page: got error %v, want %v", size, err, raster.ErrLimitExceeded)
+		}
+		if _, _, err := image.Decode(bytes.NewReader(buf.Bytes())); !errors.Is(err, raster.ErrLimitExceeded) {
+			t.Errorf("Decode of a %v page: got error %v, want %v", size, err, raster.ErrLimitExceeded)
+		}
+	}
+}
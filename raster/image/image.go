@@ -1,100 +1,440 @@
 // Package image allows using CUPS raster pages in combination with
 // image.Image.
+//
+// Importing this package registers the CUPS raster format with the
+// image package, so that image.Decode and image.DecodeConfig can read
+// the first page of a raster stream, in any version and byte order.
+// image.Decode returns the image that Image returns for the page, and
+// image.DecodeConfig only reads the page header. As the input of
+// image.Decode is often untrusted, pages wider or taller than 65536
+// pixels, or with more than 1 GiB of image data, are rejected with
+// raster.ErrLimitExceeded. Use a raster.Decoder with suitable
+// raster.DecoderOptions to decode larger pages.
+//
+// Conversely, Rasterizer and Encode convert images to the image data
+// of raster pages.
 package image
 
 import (
+	"context"
+	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
+	"math"
 
 	"honnef.co/go/cups/raster"
 )
 
-// FIXME respect bounding boxes
+// Images are placed in a coordinate system whose origin is the top
+// left corner of the sheet, measured in device pixels. The raster
+// data of a page only covers its imageable area, which is described
+// by the page's bounding box.
 
+// rect returns the position of the page's raster data on the sheet.
 func rect(p *raster.Page) image.Rectangle {
-	// TODO respect bounding box
-	return image.Rect(0, 0, int(p.Header.CUPS.Width), int(p.Header.CUPS.Height))
+	h := p.Header
+	_, l, _ := pageSize(h)
+	var x, y int
+	switch bb := h.CUPS.ImagingBBox; {
+	case l <= 0:
+	case bb.Right > bb.Left:
+		x = points(float64(bb.Left), h.HorizDPI)
+		y = points(l-float64(bb.Top), h.VertDPI)
+	case h.BoundingBox.Right > h.BoundingBox.Left:
+		x = points(float64(h.BoundingBox.Left), h.HorizDPI)
+		y = points(l-float64(h.BoundingBox.Top), h.VertDPI)
+	default:
+		// Without a bounding box, only the left and bottom margins
+		// are known.
+		x = points(float64(h.MarginLeft), h.HorizDPI)
+		if h.MarginBottom > 0 {
+			y = points(l-float64(h.MarginBottom), h.VertDPI) - int(h.CUPS.Height)
+		}
+	}
+	if y < 0 {
+		y = 0
+	}
+	return image.Rect(x, y, x+int(h.CUPS.Width), y+int(h.CUPS.Height))
+}
+
+// sheet returns the size of the entire sheet, in device pixels. It
+// always contains rect(p).
+func sheet(p *raster.Page) image.Rectangle {
+	w, l, exact := pageSize(p.Header)
+	s := image.Rect(0, 0, points(w, p.Header.HorizDPI), points(l, p.Header.VertDPI))
+	r := rect(p)
+	if !exact {
+		// Page sizes in whole points are rounded; don't add a
+		// margin that is smaller than the rounding error.
+		if s.Max.X-r.Max.X < points(1, p.Header.HorizDPI) {
+			s.Max.X = r.Max.X
+		}
+		if s.Max.Y-r.Max.Y < points(1, p.Header.VertDPI) {
+			s.Max.Y = r.Max.Y
+		}
+	}
+	return s.Union(r)
+}
+
+// pageSize returns the size of the sheet, in points, and whether
+// it is exact or rounded to whole points.
+func pageSize(h *raster.Header) (w, l float64, exact bool) {
+	if h.CUPS.PageSize[0] > 0 && h.CUPS.PageSize[1] > 0 {
+		return float64(h.CUPS.PageSize[0]), float64(h.CUPS.PageSize[1]), true
+	}
+	return float64(h.Width), float64(h.Length), false
 }
 
-// Image returns an image.Image of the page.
+// points converts v points to device pixels.
+func points(v float64, dpi int) int {
+	return int(math.Floor(v*float64(dpi)/72 + 0.5))
+}
+
+// Image returns an image.Image of the page. Its bounds are the
+// position of the page's imageable area on the sheet, in device
+// pixels. Use SheetImage to get an image of the entire sheet.
 //
 // Depending on the color space and bit depth used, image.Image
 // implementations from this package or from the Go standard library
 // image package may be used. The mapping is as follows:
 //
 // 	- 1-bit, ColorSpaceBlack -> *Monochrome
-// 	- 8-bit, ColorSpaceBlack -> *image.Gray
-// 	- 8-bit, ColorSpaceCMYK -> *image.CMYK
-// 	- Other combinations are not currently supported and will return
-// 	  ErrUnsupported. They might be added in the future.
+// 	- 2- and 4-bit ColorSpaceGray, ColorSpacesGray and
+// 	  ColorSpaceBlack -> *Gray2 and *Gray4
+// 	- 1-, 2- and 4-bit ColorSpaceCMYK -> *CMYK1, *CMYK2 and *CMYK4
+// 	- ColorSpaceGray, ColorSpacesGray, ColorSpaceBlack,
+// 	  ColorSpaceWHITE, ColorSpaceGOLD, ColorSpaceSILVER ->
+// 	  *image.Gray, or *image.Gray16 for 16-bit colors
+// 	- ColorSpaceRGBA -> *image.NRGBA, or *image.NRGBA64 for 16-bit
+// 	  colors
+// 	- ColorSpaceCMY, ColorSpaceYMC, ColorSpaceCMYK,
+// 	  ColorSpaceYMCK, ColorSpaceKCMY, ColorSpaceKCMYcm,
+// 	  ColorSpaceGMCK, ColorSpaceGMCS -> *image.CMYK
+// 	- All other color spaces -> *image.RGBA, or *image.RGBA64 for
+// 	  16-bit colors
+//
+// Pages with BandedPixels and PlanarPixels are supported as well.
+// Pages with ChunkyPixels share their memory with the returned image
+// if they are mapped to one of the packed image types of this
+// package, or if they are 8-bit gray, black, RGBA or CMYK pages. All
+// other pages are converted line by line using the typed line
+// accessors of raster.Page, such as ReadLineGray.
+// Data with fewer than 8 bits per color is scaled to 8 bits, and
+// 16-bit CMYK data is reduced to 8 bits.
 //
 // No calls to ReadLine or ReadAll must be made before or after
 // calling Image. That is, Image consumes the entire stream of the
 // page.
 //
 // Note that decoding an entire page at once may use considerable
-// amounts of memory. For efficient, line-wise processing, a
-// combination of ReadLine and ParseColors should be used instead.
+// amounts of memory. For efficient, line-wise processing, the typed
+// line accessors of raster.Page, such as ReadLineRGBA, should be used
+// instead, or a Stream, which decodes lines as they are accessed.
+// raster.DecoderOptions.MaxPageBytes only bounds the page's raw image
+// data, not the converted image, which uses up to 8 bytes per pixel
+// regardless of the page's bit depth. When decoding untrusted input,
+// limit CUPS.Width and CUPS.Height with raster.DecoderOptions as well.
 func Image(p *raster.Page) (image.Image, error) {
-	b := make([]byte, p.Size())
-	err := p.ReadAll(b)
+	return ImageContext(context.Background(), p)
+}
+
+// ImageContext is like Image, but stops reading and converting the
+// page when ctx is canceled, returning ctx.Err(). Cancellation is
+// checked before every line.
+func ImageContext(ctx context.Context, p *raster.Page) (image.Image, error) {
+	size := p.Size()
+	if size == math.MaxInt {
+		return nil, fmt.Errorf("%w: page size overflows", raster.ErrLimitExceeded)
+	}
+	b := make([]byte, size)
+	err := p.ReadAllContext(ctx, b)
 	if err != nil {
 		return nil, err
 	}
+	return fromData(ctx, p, b)
+}
+
+// FromData is like Image, but uses the page's image data in b, which
+// has been read already, for example by raster.Decoder.Pipeline. b
+// may be modified, and the returned image may share memory with it.
+func FromData(p *raster.Page, b []byte) (image.Image, error) {
+	return fromData(context.Background(), p, b)
+}
+
+func fromData(ctx context.Context, p *raster.Page, b []byte) (image.Image, error) {
+	if len(b) < p.Header.CUPS.BytesPerLine*p.Header.CUPS.Height {
+		return nil, raster.ErrBufferTooSmall
+	}
+	if img, invert := view(p, b, rect(p)); img != nil {
+		if invert != nil {
+			invert(b)
+		}
+		return img, nil
+	}
+	return convert(ctx, p, b)
+}
 
-	// FIXME support color orders other than chunked
+// view returns an image of the lines of p in b, covering r, which
+// shares its memory with b. It returns nil if the format of p has no
+// such representation. If invert is not nil, it has to be called on
+// every line of b first.
+func view(p *raster.Page, b []byte, r image.Rectangle) (img image.Image, invert func(line []byte)) {
 	if p.Header.CUPS.ColorOrder != raster.ChunkyPixels {
-		return nil, raster.ErrUnsupported
+		return nil, nil
 	}
-	switch p.Header.CUPS.ColorSpace {
-	case raster.ColorSpaceBlack:
-		switch p.Header.CUPS.BitsPerColor {
-		case 1:
+	stride := int(p.Header.CUPS.BytesPerLine)
+	bpc := p.Header.CUPS.BitsPerColor
+	bpp := p.Header.CUPS.BitsPerPixel
+	switch bpc {
+	case 1:
+		switch p.Header.CUPS.ColorSpace {
+		case raster.ColorSpaceBlack:
 			return &Monochrome{
 				Pix:    b,
-				Stride: int(p.Header.CUPS.BytesPerLine),
-				Rect:   rect(p),
+				Stride: stride,
+				Rect:   r,
 			}, nil
-		case 8:
-			for i, v := range b {
-				b[i] = 255 - v
+		case raster.ColorSpaceCMYK:
+			if bpp == 4 {
+				return &CMYK1{Pix: b, Stride: stride, Rect: r}, nil
+			}
+		}
+	case 2, 4:
+		switch p.Header.CUPS.ColorSpace {
+		case raster.ColorSpaceBlack:
+			invert = invertBits
+			fallthrough
+		case raster.ColorSpaceGray, raster.ColorSpacesGray:
+			if bpp != bpc {
+				break
+			}
+			if bpc == 2 {
+				return &Gray2{Pix: b, Stride: stride, Rect: r}, invert
 			}
+			return &Gray4{Pix: b, Stride: stride, Rect: r}, invert
+		case raster.ColorSpaceCMYK:
+			if bpp != 4*bpc {
+				break
+			}
+			if bpc == 2 {
+				return &CMYK2{Pix: b, Stride: stride, Rect: r}, nil
+			}
+			return &CMYK4{Pix: b, Stride: stride, Rect: r}, nil
+		}
+	case 8:
+		switch p.Header.CUPS.ColorSpace {
+		case raster.ColorSpaceBlack:
+			invert = invertBits
+			fallthrough
+		case raster.ColorSpaceGray, raster.ColorSpacesGray:
 			return &image.Gray{
 				Pix:    b,
-				Stride: int(p.Header.CUPS.BytesPerLine),
-				Rect:   rect(p),
+				Stride: stride,
+				Rect:   r,
+			}, invert
+		case raster.ColorSpaceRGBA:
+			return &image.NRGBA{
+				Pix:    b,
+				Stride: stride,
+				Rect:   r,
 			}, nil
-		default:
-			return nil, raster.ErrUnsupported
-		}
-	case raster.ColorSpaceCMYK:
-		if p.Header.CUPS.BitsPerColor != 8 {
-			return nil, raster.ErrUnsupported
-		}
-		// TODO does cups have a byte order for colors in a pixel and
-		// do we need to swap bytes?
-		return &image.CMYK{
-			Pix:    b,
-			Stride: int(p.Header.CUPS.BytesPerLine),
-			Rect:   rect(p),
-		}, nil
+		case raster.ColorSpaceCMYK:
+			return &image.CMYK{
+				Pix:    b,
+				Stride: stride,
+				Rect:   r,
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// invertBits inverts all samples in b, turning amounts of ink into
+// gray levels.
+func invertBits(b []byte) {
+	for i, v := range b {
+		b[i] = ^v
+	}
+}
+
+// convert converts the page data in b to an image, using the typed
+// line accessors of raster.Page.
+func convert(ctx context.Context, p *raster.Page, b []byte) (image.Image, error) {
+	r := rect(p)
+	img, line := newConverter(p.WithData(b), r)
+	for y := 0; y < r.Dy(); y++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := line(y); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// newConverter returns a new image with bounds r, of the type that
+// Image uses for pages that are converted, and a function that reads
+// the next line of p into the y-th line of the image, counting from
+// r.Min.Y.
+func newConverter(p *raster.Page, r image.Rectangle) (image.Image, func(y int) error) {
+	w := r.Dx()
+	deep := p.Header.CUPS.BitsPerColor == 16
+	switch p.Header.CUPS.ColorSpace {
+	case raster.ColorSpaceGray, raster.ColorSpacesGray, raster.ColorSpaceBlack,
+		raster.ColorSpaceWHITE, raster.ColorSpaceGOLD, raster.ColorSpaceSILVER:
+		if deep {
+			img := image.NewGray16(r)
+			buf := make([]uint16, w)
+			return img, func(y int) error {
+				if err := p.ReadLineGray16(buf); err != nil {
+					return err
+				}
+				pix := img.Pix[y*img.Stride:]
+				for x, v := range buf {
+					pix[2*x] = uint8(v >> 8)
+					pix[2*x+1] = uint8(v)
+				}
+				return nil
+			}
+		}
+		img := image.NewGray(r)
+		return img, func(y int) error {
+			return p.ReadLineGray(img.Pix[y*img.Stride : y*img.Stride+w])
+		}
+	case raster.ColorSpaceRGBA:
+		if deep {
+			img := image.NewNRGBA64(r)
+			buf := make([]color.NRGBA64, w)
+			return img, func(y int) error {
+				if err := p.ReadLineNRGBA64(buf); err != nil {
+					return err
+				}
+				pix := img.Pix[y*img.Stride:]
+				for x, c := range buf {
+					put16(pix[8*x:], c.R, c.G, c.B, c.A)
+				}
+				return nil
+			}
+		}
+		img := image.NewNRGBA(r)
+		buf := make([]color.NRGBA, w)
+		return img, func(y int) error {
+			if err := p.ReadLineNRGBA(buf); err != nil {
+				return err
+			}
+			pix := img.Pix[y*img.Stride:]
+			for x, c := range buf {
+				pix[4*x], pix[4*x+1], pix[4*x+2], pix[4*x+3] = c.R, c.G, c.B, c.A
+			}
+			return nil
+		}
+	case raster.ColorSpaceCMY, raster.ColorSpaceYMC, raster.ColorSpaceCMYK,
+		raster.ColorSpaceYMCK, raster.ColorSpaceKCMY, raster.ColorSpaceKCMYcm,
+		raster.ColorSpaceGMCK, raster.ColorSpaceGMCS:
+		img := image.NewCMYK(r)
+		buf := make([]color.CMYK, w)
+		return img, func(y int) error {
+			if err := p.ReadLineCMYK(buf); err != nil {
+				return err
+			}
+			pix := img.Pix[y*img.Stride:]
+			for x, c := range buf {
+				pix[4*x], pix[4*x+1], pix[4*x+2], pix[4*x+3] = c.C, c.M, c.Y, c.K
+			}
+			return nil
+		}
 	default:
-		return nil, raster.ErrUnsupported
+		if deep {
+			img := image.NewRGBA64(r)
+			buf := make([]color.RGBA64, w)
+			return img, func(y int) error {
+				if err := p.ReadLineRGBA64(buf); err != nil {
+					return err
+				}
+				pix := img.Pix[y*img.Stride:]
+				for x, c := range buf {
+					put16(pix[8*x:], c.R, c.G, c.B, c.A)
+				}
+				return nil
+			}
+		}
+		img := image.NewRGBA(r)
+		buf := make([]color.RGBA, w)
+		return img, func(y int) error {
+			if err := p.ReadLineRGBA(buf); err != nil {
+				return err
+			}
+			pix := img.Pix[y*img.Stride:]
+			for x, c := range buf {
+				pix[4*x], pix[4*x+1], pix[4*x+2], pix[4*x+3] = c.R, c.G, c.B, c.A
+			}
+			return nil
+		}
+	}
+}
+
+// put16 stores four 16-bit values in big-endian order, the layout of
+// image.RGBA64 and image.NRGBA64.
+func put16(b []uint8, v0, v1, v2, v3 uint16) {
+	b[0], b[1] = uint8(v0>>8), uint8(v0)
+	b[2], b[3] = uint8(v1>>8), uint8(v1)
+	b[4], b[5] = uint8(v2>>8), uint8(v2)
+	b[6], b[7] = uint8(v3>>8), uint8(v3)
+}
+
+var (
+	_ draw.RGBA64Image = (*Monochrome)(nil)
+
+	// MonochromeModel converts colors to black or white color.Gray
+	// values, like Monochrome.Set does.
+	MonochromeModel color.Model = color.ModelFunc(monochromeModel)
+)
+
+func monochromeModel(c color.Color) color.Color {
+	if isBlack(color.GrayModel.Convert(c).(color.Gray)) {
+		return color.Gray{Y: 0}
 	}
+	return color.Gray{Y: 255}
 }
 
-var _ image.Image = (*Monochrome)(nil)
+// isBlack reports whether c is closer to black than to white.
+func isBlack(c color.Gray) bool {
+	return c.Y < 128
+}
 
 // Monochrome is an in-memory monochromatic image, with 8 pixels
-// packed into one byte. Its At method returns color.Gray values.
+// packed into one byte. A set bit is a black pixel, like in pages
+// with ColorSpaceBlack. Its At method returns color.Gray values.
+//
+// Monochrome implements draw.RGBA64Image, which lets draw.Draw avoid
+// allocating a color.Color per pixel. Draw is faster still, for
+// drawing onto and from Monochrome images.
 type Monochrome struct {
 	Pix    []uint8
 	Stride int
 	Rect   image.Rectangle
+
+	// bit is the position of the pixel at Rect.Min.X in its byte,
+	// counting from the most significant bit. It is only nonzero
+	// for images returned by SubImage.
+	bit int
+}
+
+// NewMonochrome returns a new, white Monochrome image with the given
+// bounds.
+func NewMonochrome(r image.Rectangle) *Monochrome {
+	stride := (r.Dx() + 7) / 8
+	return &Monochrome{
+		Pix:    make([]uint8, stride*r.Dy()),
+		Stride: stride,
+		Rect:   r,
+	}
 }
 
 func (img *Monochrome) ColorModel() color.Model {
-	return color.GrayModel
+	return MonochromeModel
 }
 
 func (img *Monochrome) Bounds() image.Rectangle {
@@ -102,16 +442,275 @@ func (img *Monochrome) Bounds() image.Rectangle {
 }
 
 func (img *Monochrome) At(x, y int) color.Color {
-	idx := img.PixOffset(x, y)
-	if img.Pix[idx]<<uint(x%8)&128 == 0 {
-		return color.Gray{Y: 255}
+	return img.GrayAt(x, y)
+}
+
+func (img *Monochrome) RGBA64At(x, y int) color.RGBA64 {
+	v := uint16(img.GrayAt(x, y).Y) * 0x101
+	return color.RGBA64{v, v, v, 0xffff}
+}
+
+func (img *Monochrome) GrayAt(x, y int) color.Gray {
+	if !(image.Point{x, y}.In(img.Rect)) {
+		return color.Gray{}
 	}
-	return color.Gray{Y: 0}
+	i, mask := img.bitOffset(x, y)
+	if img.Pix[i]&mask != 0 {
+		return color.Gray{Y: 0}
+	}
+	return color.Gray{Y: 255}
 }
 
 // PixOffset returns the index of the first element of Pix that
-// corresponds to the pixel at (x, y).
+// corresponds to the pixel at (x, y). The first pixel of each line,
+// at Rect.Min.X, is stored in the most significant bit of a byte,
+// unless the image has been returned by SubImage.
 func (img *Monochrome) PixOffset(x, y int) int {
-	// TODO respect non-zero starting point of bounding box
-	return y*img.Stride + (x / 8)
+	i, _ := img.bitOffset(x, y)
+	return i
+}
+
+// bitOffset returns the index of the element of Pix that holds the
+// pixel at (x, y), and the pixel's bit in it.
+func (img *Monochrome) bitOffset(x, y int) (int, uint8) {
+	pos := img.bitPos(x, y)
+	return pos / 8, 128 >> uint(pos%8)
+}
+
+// bitPos returns the position of the bit of the pixel at (x, y) in
+// Pix, counting from the most significant bit of the first byte.
+func (img *Monochrome) bitPos(x, y int) int {
+	return (y-img.Rect.Min.Y)*img.Stride*8 + x - img.Rect.Min.X + img.bit
+}
+
+// Set sets the pixel at (x, y) to black or white, whichever is closer
+// to c's luminance.
+func (img *Monochrome) Set(x, y int, c color.Color) {
+	img.SetGray(x, y, color.GrayModel.Convert(c).(color.Gray))
+}
+
+func (img *Monochrome) SetRGBA64(x, y int, c color.RGBA64) {
+	img.SetGray(x, y, color.Gray{Y: luminance(c)})
+}
+
+// SetGray sets the pixel at (x, y) to black or white, whichever is
+// closer to c.
+func (img *Monochrome) SetGray(x, y int, c color.Gray) {
+	if !(image.Point{x, y}.In(img.Rect)) {
+		return
+	}
+	i, mask := img.bitOffset(x, y)
+	if isBlack(c) {
+		img.Pix[i] |= mask
+	} else {
+		img.Pix[i] &^= mask
+	}
+}
+
+// SubImage returns an image representing the portion of the image
+// visible through r. The returned value shares pixels with the
+// original image.
+func (img *Monochrome) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(img.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed
+	// to be inside either r1 or r2 if the intersection is empty.
+	// Without explicitly checking for this, the Pix[i:] expression
+	// below can panic.
+	if r.Empty() {
+		return &Monochrome{}
+	}
+	i, _ := img.bitOffset(r.Min.X, r.Min.Y)
+	return &Monochrome{
+		Pix:    img.Pix[i:],
+		Stride: img.Stride,
+		Rect:   r,
+		bit:    (r.Min.X - img.Rect.Min.X + img.bit) % 8,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully
+// opaque, which a Monochrome image always is.
+func (img *Monochrome) Opaque() bool {
+	return true
+}
+
+// Draw is like draw.Draw with draw.Src. Instead of working per pixel,
+// it copies a byte at a time between Monochrome images, as well as
+// from *image.Gray to Monochrome images, and from Monochrome images
+// to *image.Gray. Gray pixels are turned black or white like
+// Monochrome.SetGray does. Other images are drawn by draw.Draw.
+func Draw(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point) {
+	switch dst := dst.(type) {
+	case *Monochrome:
+		switch src := src.(type) {
+		case *Monochrome:
+			drawMonochrome(dst, r, src, sp)
+			return
+		case *image.Gray:
+			drawGrayMonochrome(dst, r, src, sp)
+			return
+		}
+	case *image.Gray:
+		if src, ok := src.(*Monochrome); ok {
+			drawMonochromeGray(dst, r, src, sp)
+			return
+		}
+	}
+	draw.Draw(dst, r, src, sp, draw.Src)
+}
+
+// sameBuffer reports whether a and b are slices of the same array,
+// like the Pix of an image and of its sub-images.
+func sameBuffer(a, b []uint8) bool {
+	return cap(a) > 0 && cap(b) > 0 && &a[:cap(a)][cap(a)-1] == &b[:cap(b)][cap(b)-1]
+}
+
+// clip clips r to the bounds of dst, and to those of src, moved so
+// that sp is at r.Min, like draw.Draw does. It returns the clipped
+// rectangle and the point of src that is drawn at its minimum.
+func clip(dst, src image.Rectangle, r image.Rectangle, sp image.Point) (image.Rectangle, image.Point) {
+	d := r.Min.Sub(sp)
+	r = r.Intersect(dst).Intersect(src.Add(d))
+	return r, r.Min.Sub(d)
+}
+
+// drawMonochrome copies the pixels of src at sp to the rectangle r
+// of dst, a byte at a time.
+func drawMonochrome(dst *Monochrome, r image.Rectangle, src *Monochrome, sp image.Point) {
+	r, sp = clip(dst.Rect, src.Rect, r, sp)
+	if sameBuffer(dst.Pix, src.Pix) {
+		// The images may overlap; copy the source pixels first.
+		sr := image.Rectangle{sp, sp.Add(r.Size())}
+		tmp := NewMonochrome(sr)
+		drawMonochrome(tmp, sr, src, sp)
+		src = tmp
+	}
+	for y := 0; y < r.Dy(); y++ {
+		copyBits(dst.Pix, dst.bitPos(r.Min.X, r.Min.Y+y), src.Pix, src.bitPos(sp.X, sp.Y+y), r.Dx())
+	}
+}
+
+// drawGrayMonochrome copies the pixels of src at sp to the rectangle
+// r of dst, turning them black or white, 8 pixels at a time.
+func drawGrayMonochrome(dst *Monochrome, r image.Rectangle, src *image.Gray, sp image.Point) {
+	r, sp = clip(dst.Rect, src.Rect, r, sp)
+	var b [1]uint8
+	for y := 0; y < r.Dy(); y++ {
+		s := src.Pix[src.PixOffset(sp.X, sp.Y+y):]
+		pos := dst.bitPos(r.Min.X, r.Min.Y+y)
+		for x := 0; x < r.Dx(); x += 8 {
+			n := r.Dx() - x
+			if n > 8 {
+				n = 8
+			}
+			b[0] = 0
+			for i, v := range s[x : x+n] {
+				if isBlack(color.Gray{Y: v}) {
+					b[0] |= 128 >> uint(i)
+				}
+			}
+			copyBits(dst.Pix, pos+x, b[:], 0, n)
+		}
+	}
+}
+
+// drawMonochromeGray copies the pixels of src at sp to the rectangle
+// r of dst, reading 8 pixels at a time.
+func drawMonochromeGray(dst *image.Gray, r image.Rectangle, src *Monochrome, sp image.Point) {
+	r, sp = clip(dst.Rect, src.Rect, r, sp)
+	var b [1]uint8
+	for y := 0; y < r.Dy(); y++ {
+		d := dst.Pix[dst.PixOffset(r.Min.X, r.Min.Y+y):]
+		pos := src.bitPos(sp.X, sp.Y+y)
+		for x := 0; x < r.Dx(); x += 8 {
+			n := r.Dx() - x
+			if n > 8 {
+				n = 8
+			}
+			copyBits(b[:], 0, src.Pix, pos+x, n)
+			for i := range d[x : x+n] {
+				if b[0]&(128>>uint(i)) != 0 {
+					d[x+i] = 0
+				} else {
+					d[x+i] = 255
+				}
+			}
+		}
+	}
+}
+
+// copyBits copies n bits from src, starting at bit spos, to dst,
+// starting at bit dpos. Bits are counted from the most significant
+// bit of the first byte.
+func copyBits(dst []uint8, dpos int, src []uint8, spos int, n int) {
+	for n > 0 {
+		// Fill the rest of the current byte of dst.
+		k := 8 - dpos%8
+		if k > n {
+			k = n
+		}
+		v := uint16(src[spos/8]) << 8
+		if spos%8+k > 8 {
+			v |= uint16(src[spos/8+1])
+		}
+		bits := uint8(v<<uint(spos%8)>>8) >> uint(8-k)
+		shift := uint(8 - dpos%8 - k)
+		mask := uint8(1<<uint(k)-1) << shift
+		dst[dpos/8] = dst[dpos/8]&^mask | bits<<shift
+		dpos += k
+		spos += k
+		n -= k
+	}
+}
+
+// SheetImage is like Image, but returns an image of the entire
+// sheet, with bounds starting at (0, 0). Areas outside of the
+// imageable area are white. Unlike with Image, the returned image
+// never shares memory with the page.
+//
+// The size of the sheet is computed from the page's PageSize, or
+// Width and Length, and its resolution. These fields aren't checked
+// by any of the limits in raster.DecoderOptions, so callers decoding
+// untrusted input should check them before calling SheetImage.
+func SheetImage(p *raster.Page) (image.Image, error) {
+	img, err := Image(p)
+	if err != nil {
+		return nil, err
+	}
+	r := sheet(p)
+	var dst draw.Image
+	switch img := img.(type) {
+	case *Monochrome:
+		// New images are white already.
+		m := NewMonochrome(r)
+		drawMonochrome(m, img.Rect, img, img.Rect.Min)
+		return m, nil
+	case *Gray2:
+		dst = NewGray2(r)
+	case *Gray4:
+		dst = NewGray4(r)
+	case *CMYK1:
+		dst = NewCMYK1(r)
+	case *CMYK2:
+		dst = NewCMYK2(r)
+	case *CMYK4:
+		dst = NewCMYK4(r)
+	case *image.Gray:
+		dst = image.NewGray(r)
+	case *image.Gray16:
+		dst = image.NewGray16(r)
+	case *image.NRGBA:
+		dst = image.NewNRGBA(r)
+	case *image.NRGBA64:
+		dst = image.NewNRGBA64(r)
+	case *image.CMYK:
+		dst = image.NewCMYK(r)
+	case *image.RGBA64:
+		dst = image.NewRGBA64(r)
+	default:
+		dst = image.NewRGBA(r)
+	}
+	draw.Draw(dst, r, image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, img.Bounds(), img, img.Bounds().Min, draw.Src)
+	return dst, nil
 }
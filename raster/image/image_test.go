@@ -0,0 +1,388 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"reflect"
+	"testing"
+
+	"honnef.co/go/cups/raster"
+)
+
+// encodePage encodes a stream with a single page and returns the
+// stream.
+func encodePage(h *raster.Header, lines [][]byte, version int, bo binary.ByteOrder, t testing.TB) []byte {
+	var buf bytes.Buffer
+	e, err := raster.NewEncoder(&buf, version, bo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WritePage(h); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range lines {
+		if err := e.WriteLine(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decodePage returns the first page of the stream in b.
+func decodePage(b []byte, t testing.TB) *raster.Page {
+	d, err := raster.NewDecoder(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := d.NextPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// newPage encodes a page with the header h and the given lines, and
+// returns it decoded again.
+func newPage(h *raster.Header, lines [][]byte, t testing.TB) *raster.Page {
+	return decodePage(encodePage(h, lines, 3, binary.BigEndian, t), t)
+}
+
+func TestGeometry(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(h *raster.Header)
+		rect   image.Rectangle
+		sheet  image.Rectangle
+	}{
+		{
+			"no page size",
+			func(h *raster.Header) {},
+			image.Rect(0, 0, 50, 160),
+			image.Rect(0, 0, 50, 160),
+		},
+		{
+			"ImagingBBox",
+			func(h *raster.Header) {
+				h.CUPS.PageSize = [2]float32{100, 200}
+				h.CUPS.ImagingBBox = raster.CUPSBoundingBox{Left: 10, Bottom: 20, Right: 60, Top: 180}
+				// ImagingBBox takes precedence.
+				h.BoundingBox = raster.BoundingBox{Left: 1, Bottom: 2, Right: 3, Top: 4}
+			},
+			image.Rect(10, 20, 60, 180),
+			image.Rect(0, 0, 100, 200),
+		},
+		{
+			"BoundingBox",
+			func(h *raster.Header) {
+				h.Width, h.Length = 100, 200
+				h.BoundingBox = raster.BoundingBox{Left: 10, Bottom: 20, Right: 60, Top: 180}
+			},
+			image.Rect(10, 20, 60, 180),
+			image.Rect(0, 0, 100, 200),
+		},
+		{
+			"margins",
+			func(h *raster.Header) {
+				h.CUPS.PageSize = [2]float32{100, 200}
+				h.MarginLeft, h.MarginBottom = 5, 30
+			},
+			image.Rect(5, 10, 55, 170),
+			image.Rect(0, 0, 100, 200),
+		},
+		{
+			"left margin",
+			func(h *raster.Header) {
+				h.CUPS.PageSize = [2]float32{100, 200}
+				h.MarginLeft = 5
+			},
+			image.Rect(5, 0, 55, 160),
+			image.Rect(0, 0, 100, 200),
+		},
+		{
+			"raster data larger than the sheet",
+			func(h *raster.Header) {
+				h.CUPS.PageSize = [2]float32{40, 100}
+				h.CUPS.ImagingBBox = raster.CUPSBoundingBox{Left: 10, Bottom: 0, Right: 60, Top: 90}
+			},
+			image.Rect(10, 10, 60, 170),
+			image.Rect(0, 0, 60, 170),
+		},
+		{
+			"300 DPI",
+			func(h *raster.Header) {
+				h.HorizDPI, h.VertDPI = 300, 300
+				h.CUPS.PageSize = [2]float32{36, 72}
+				h.CUPS.ImagingBBox = raster.CUPSBoundingBox{Left: 3.6, Bottom: 0, Right: 15.6, Top: 60}
+			},
+			image.Rect(15, 50, 65, 210),
+			image.Rect(0, 0, 150, 300),
+		},
+		{
+			"rounded page size",
+			func(h *raster.Header) {
+				// 12 points at 300 DPI are 50 pixels. A margin of
+				// less than a point is a rounding error, but 40
+				// points are 7 pixels more than the raster data.
+				h.HorizDPI, h.VertDPI = 300, 300
+				h.Width, h.Length = 12, 40
+			},
+			image.Rect(0, 0, 50, 160),
+			image.Rect(0, 0, 50, 167),
+		},
+	}
+	for _, tt := range tests {
+		h := &raster.Header{HorizDPI: 72, VertDPI: 72}
+		h.CUPS.Width = 50
+		h.CUPS.Height = 160
+		tt.modify(h)
+		p := &raster.Page{Header: h}
+		if got := rect(p); got != tt.rect {
+			t.Errorf("%s: got rect %v, want %v", tt.name, got, tt.rect)
+		}
+		if got := sheet(p); got != tt.sheet {
+			t.Errorf("%s: got sheet %v, want %v", tt.name, got, tt.sheet)
+		}
+	}
+}
+
+func TestMonochromeOrigin(t *testing.T) {
+	h := &raster.Header{HorizDPI: 72, VertDPI: 72}
+	h.CUPS.Width = 12
+	h.CUPS.Height = 2
+	h.CUPS.ColorSpace = raster.ColorSpaceBlack
+	h.CUPS.BitsPerColor = 1
+	h.CUPS.BitsPerPixel = 1
+	h.CUPS.BytesPerLine = 2
+	h.CUPS.PageSize = [2]float32{20, 10}
+	h.CUPS.ImagingBBox = raster.CUPSBoundingBox{Left: 3, Bottom: 7, Right: 15, Top: 9}
+	lines := [][]byte{{0x80, 0x10}, {0x40, 0x00}}
+	// The black pixels of the raster data, on the sheet.
+	black := map[image.Point]bool{{3, 1}: true, {14, 1}: true, {4, 2}: true}
+
+	check := func(name string, img image.Image, want image.Rectangle) {
+		if img.Bounds() != want {
+			t.Errorf("%s: got bounds %v, want %v", name, img.Bounds(), want)
+		}
+		r := img.Bounds()
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				want := color.Gray{Y: 255}
+				if black[image.Point{x, y}] {
+					want = color.Gray{Y: 0}
+				}
+				if got := img.At(x, y); got != want {
+					t.Errorf("%s: pixel (%d, %d) is %v, want %v", name, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	img, err := Image(newPage(h, lines, t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := img.(*Monochrome); !ok {
+		t.Fatalf("got %T, want *Monochrome", img)
+	}
+	check("Image", img, image.Rect(3, 1, 15, 3))
+
+	img, err = SheetImage(newPage(h, lines, t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("SheetImage", img, image.Rect(0, 0, 20, 10))
+}
+
+func TestSheetImage(t *testing.T) {
+	h := &raster.Header{HorizDPI: 72, VertDPI: 72}
+	h.CUPS.Width = 2
+	h.CUPS.Height = 2
+	h.CUPS.ColorSpace = raster.ColorSpaceGray
+	h.CUPS.BitsPerColor = 8
+	h.CUPS.BitsPerPixel = 8
+	h.CUPS.BytesPerLine = 2
+	h.CUPS.PageSize = [2]float32{4, 5}
+	h.CUPS.ImagingBBox = raster.CUPSBoundingBox{Left: 1, Bottom: 1, Right: 3, Top: 3}
+	img, err := SheetImage(newPage(h, [][]byte{{0, 1}, {2, 3}}, t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, ok := img.(*image.Gray)
+	if !ok {
+		t.Fatalf("got %T, want *image.Gray", img)
+	}
+	want := []uint8{
+		255, 255, 255, 255,
+		255, 255, 255, 255,
+		255, 0, 1, 255,
+		255, 2, 3, 255,
+		255, 255, 255, 255,
+	}
+	if g.Rect != image.Rect(0, 0, 4, 5) || !bytes.Equal(g.Pix, want) {
+		t.Errorf("got %v with pixels %v, want %v with pixels %v", g.Rect, g.Pix, image.Rect(0, 0, 4, 5), want)
+	}
+}
+
+func TestMonochromeSet(t *testing.T) {
+	img := NewMonochrome(image.Rect(2, 1, 14, 3))
+	img.Set(2, 1, color.Black)
+	img.Set(3, 1, color.Gray{Y: 127})
+	img.Set(4, 1, color.Gray{Y: 128})
+	img.Set(13, 2, color.RGBA{R: 255, A: 255})
+	img.SetRGBA64(12, 2, color.RGBA64{G: 0xffff, A: 0xffff})
+	// Outside of the image.
+	img.Set(14, 2, color.Black)
+	if want := []uint8{0xC0, 0x00, 0x00, 0x10}; !bytes.Equal(img.Pix, want) {
+		t.Errorf("got pixels %08b, want %08b", img.Pix, want)
+	}
+	if got := img.At(3, 1); got != (color.Gray{Y: 0}) {
+		t.Errorf("got %v, want black", got)
+	}
+	if got := img.At(4, 1); got != (color.Gray{Y: 255}) {
+		t.Errorf("got %v, want white", got)
+	}
+}
+
+func TestMonochromeSubImage(t *testing.T) {
+	img := NewMonochrome(image.Rect(0, 0, 20, 2))
+	sub := img.SubImage(image.Rect(5, 1, 17, 2)).(*Monochrome)
+	if sub.bit != 5 {
+		t.Fatalf("got bit %d, want 5", sub.bit)
+	}
+	sub.Set(5, 1, color.Black)
+	sub.Set(12, 1, color.Black)
+	if want := []uint8{0, 0, 0, 0x04, 0x08, 0}; !bytes.Equal(img.Pix, want) {
+		t.Errorf("got pixels %08b, want %08b", img.Pix, want)
+	}
+	for _, x := range []int{5, 12} {
+		if got := img.At(x, 1); got != (color.Gray{Y: 0}) {
+			t.Errorf("pixel (%d, 1) is %v, want black", x, got)
+		}
+	}
+	// Sub-images of sub-images accumulate the bit offset.
+	subsub := sub.SubImage(image.Rect(12, 1, 14, 2)).(*Monochrome)
+	if subsub.bit != 4 || subsub.At(12, 1) != (color.Gray{Y: 0}) || subsub.At(13, 1) != (color.Gray{Y: 255}) {
+		t.Errorf("got bit %d and pixels %v, %v, want bit 4 and black, white", subsub.bit, subsub.At(12, 1), subsub.At(13, 1))
+	}
+
+	// PixOffset is relative to the image's Pix, which for sub-images
+	// is a suffix of the original image's; want is the index in the
+	// original image's Pix.
+	for _, tt := range []struct {
+		img  *Monochrome
+		x, y int
+		want int
+	}{
+		{img, 0, 0, 0},
+		{img, 8, 0, 1},
+		{img, 19, 1, 5},
+		{sub, 5, 1, 3},
+		{sub, 8, 1, 4},
+		{sub, 16, 1, 5},
+		{subsub, 12, 1, 4},
+	} {
+		got := len(img.Pix) - len(tt.img.Pix) + tt.img.PixOffset(tt.x, tt.y)
+		if got != tt.want {
+			t.Errorf("PixOffset(%d, %d) of image with bounds %v refers to byte %d, want %d", tt.x, tt.y, tt.img.Rect, got, tt.want)
+		}
+	}
+}
+
+func TestDraw(t *testing.T) {
+	mono := NewMonochrome(image.Rect(0, 0, 37, 3))
+	for i := range mono.Pix {
+		mono.Pix[i] = uint8(i*73 + 41)
+	}
+	gray := image.NewGray(image.Rect(0, 0, 37, 3))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i*37 + 100)
+	}
+	newDst := map[string]func() draw.Image{
+		"Monochrome": func() draw.Image {
+			img := NewMonochrome(image.Rect(0, 0, 48, 6))
+			for i := range img.Pix {
+				img.Pix[i] = 0x5A
+			}
+			return img
+		},
+		"Gray": func() draw.Image {
+			img := image.NewGray(image.Rect(0, 0, 48, 6))
+			for i := range img.Pix {
+				img.Pix[i] = uint8(i)
+			}
+			return img
+		},
+	}
+	for _, src := range []interface {
+		image.Image
+		SubImage(image.Rectangle) image.Image
+	}{mono, gray} {
+		for _, sr := range []image.Rectangle{
+			image.Rect(0, 0, 37, 3),
+			image.Rect(3, 1, 30, 3),
+			image.Rect(9, 0, 10, 2),
+		} {
+			for _, dp := range []image.Point{{0, 0}, {5, 2}, {11, 1}, {40, 4}} {
+				for name, newDst := range newDst {
+					s := src.SubImage(sr)
+					r := image.Rectangle{dp, dp.Add(sr.Size())}
+					want, got := newDst(), newDst()
+					draw.Draw(want, r, s, sr.Min, draw.Src)
+					Draw(got, r, s, sr.Min)
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("drawing %T %v onto %s at %v: got %v, want %v", s, sr, name, dp, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDrawMonochromeOverlap(t *testing.T) {
+	img := NewMonochrome(image.Rect(0, 0, 37, 3))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i*73 + 41)
+	}
+	for _, dp := range []image.Point{{0, 0}, {3, 0}, {-3, 0}, {5, 1}, {-5, -1}} {
+		sr := image.Rect(4, 0, 33, 3)
+		r := sr.Add(dp)
+		want := NewMonochrome(img.Rect)
+		copy(want.Pix, img.Pix)
+		draw.Draw(want, r, img, sr.Min, draw.Src)
+		got := NewMonochrome(img.Rect)
+		copy(got.Pix, img.Pix)
+		Draw(got.SubImage(r).(*Monochrome), r, got.SubImage(sr), sr.Min)
+		if !bytes.Equal(got.Pix, want.Pix) {
+			t.Errorf("moving %v by %v: got pixels %08b, want %08b", sr, dp, got.Pix, want.Pix)
+		}
+	}
+}
+
+func TestImageSizeOverflow(t *testing.T) {
+	h := &raster.Header{}
+	h.CUPS.Width = 1
+	h.CUPS.Height = 0xFFFFFFFF
+	h.CUPS.BitsPerColor = 8
+	h.CUPS.BitsPerPixel = 8
+	h.CUPS.BytesPerLine = 150000000
+	h.CUPS.ColorOrder = raster.PlanarPixels
+	h.CUPS.ColorSpace = raster.ColorSpaceDeviceF
+	var buf bytes.Buffer
+	e, err := raster.NewEncoder(&buf, 3, binary.BigEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WritePage(h); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Image(decodePage(buf.Bytes(), t)); !errors.Is(err, raster.ErrLimitExceeded) {
+		t.Errorf("got error %v, want ErrLimitExceeded", err)
+	}
+}
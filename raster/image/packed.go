@@ -0,0 +1,579 @@
+package image
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"honnef.co/go/cups/raster"
+)
+
+// The packed image types store pixels with fewer than 8 bits per
+// color, in the layout of raster pages with ChunkyPixels: the colors
+// of a pixel are adjacent, and samples are packed into bytes starting
+// at the most significant bit. The first pixel of each line, at
+// Rect.Min.X, starts at the most significant bit of a byte, unless
+// the image has been returned by SubImage.
+
+var (
+	_ draw.RGBA64Image = (*Gray2)(nil)
+	_ draw.RGBA64Image = (*Gray4)(nil)
+	_ draw.RGBA64Image = (*CMYK1)(nil)
+	_ draw.RGBA64Image = (*CMYK2)(nil)
+	_ draw.RGBA64Image = (*CMYK4)(nil)
+
+	// Gray2Model and Gray4Model convert colors to color.Gray values
+	// with 4 and 16 levels, respectively.
+	Gray2Model color.Model = grayModel(2)
+	Gray4Model color.Model = grayModel(4)
+
+	// CMYK1Model, CMYK2Model and CMYK4Model convert colors to
+	// color.CMYK values with 2, 4 and 16 levels per color,
+	// respectively.
+	CMYK1Model color.Model = cmykModel(1)
+	CMYK2Model color.Model = cmykModel(2)
+	CMYK4Model color.Model = cmykModel(4)
+)
+
+func grayModel(bpc uint) color.Model {
+	return color.ModelFunc(func(c color.Color) color.Color {
+		g := color.Gray16Model.Convert(c).(color.Gray16)
+		return color.Gray{Y: expand(quantize(g.Y, bpc), bpc)}
+	})
+}
+
+func cmykModel(bpc uint) color.Model {
+	return color.ModelFunc(func(c color.Color) color.Color {
+		k := toCMYK64(c)
+		return color.CMYK{
+			C: expand(quantize(k.C, bpc), bpc),
+			M: expand(quantize(k.M, bpc), bpc),
+			Y: expand(quantize(k.Y, bpc), bpc),
+			K: expand(quantize(k.K, bpc), bpc),
+		}
+	})
+}
+
+// quantize reduces a 16-bit value to bpc bits, at most 8, rounding to
+// the nearest level. Both the packed images and the Rasterizer use it,
+// so that they agree on the levels of colors.
+func quantize(v uint16, bpc uint) uint8 {
+	max := uint32(1)<<bpc - 1
+	return uint8((uint32(v)*max + 0x7fff) / 0xffff)
+}
+
+// expand scales a value with bpc bits to 8 bits.
+func expand(v uint8, bpc uint) uint8 {
+	return uint8(uint32(v) * 255 / (uint32(1)<<bpc - 1))
+}
+
+// packed describes the layout of a packed image: the number of bits
+// per color and the number of colors per pixel.
+type packed struct {
+	bpc uint
+	n   int
+}
+
+// pos returns the position of the first bit of the pixel at (x, y).
+func (l packed) pos(stride int, r image.Rectangle, bit, x, y int) int {
+	return (y-r.Min.Y)*stride*8 + (x-r.Min.X)*int(l.bpc)*l.n + bit
+}
+
+// get returns the i-th sample of the pixel starting at bit pos.
+func (l packed) get(pix []uint8, pos, i int) uint8 {
+	pos += i * int(l.bpc)
+	shift := uint(8-pos%8) - l.bpc
+	return pix[pos/8] >> shift & (1<<l.bpc - 1)
+}
+
+// set sets the i-th sample of the pixel starting at bit pos to v.
+func (l packed) set(pix []uint8, pos, i int, v uint8) {
+	pos += i * int(l.bpc)
+	shift := uint(8-pos%8) - l.bpc
+	mask := uint8(1<<l.bpc-1) << shift
+	pix[pos/8] = pix[pos/8]&^mask | v<<shift&mask
+}
+
+// stride returns the number of bytes per line of an image of width w.
+func (l packed) stride(w int) int {
+	return (w*int(l.bpc)*l.n + 7) / 8
+}
+
+// subImage returns the position of the first byte of r, and the bit
+// at which its first pixel starts.
+func (l packed) subImage(stride int, r, sub image.Rectangle, bit int) (int, int) {
+	pos := l.pos(stride, r, bit, sub.Min.X, sub.Min.Y)
+	return pos / 8, pos % 8
+}
+
+// luminance returns the 8-bit luminance of c, like color.GrayModel.
+func luminance(c color.RGBA64) uint8 {
+	return uint8(luminance16(c) >> 8)
+}
+
+// luminance16 returns the 16-bit luminance of c, like
+// color.Gray16Model.
+func luminance16(c color.RGBA64) uint16 {
+	return uint16((19595*uint32(c.R) + 38470*uint32(c.G) + 7471*uint32(c.B) + 1<<15) >> 16)
+}
+
+var (
+	gray2 = packed{bpc: 2, n: 1}
+	gray4 = packed{bpc: 4, n: 1}
+	cmyk1 = packed{bpc: 1, n: 4}
+	cmyk2 = packed{bpc: 2, n: 4}
+	cmyk4 = packed{bpc: 4, n: 4}
+)
+
+// Gray2 is an in-memory image of 2-bit gray levels, with 4 pixels
+// packed into one byte. Its At method returns color.Gray values.
+type Gray2 struct {
+	Pix    []uint8
+	Stride int
+	Rect   image.Rectangle
+
+	// bit is the position of the pixel at Rect.Min.X in its byte.
+	bit int
+}
+
+// NewGray2 returns a new, black Gray2 image with the given bounds.
+func NewGray2(r image.Rectangle) *Gray2 {
+	stride := gray2.stride(r.Dx())
+	return &Gray2{Pix: make([]uint8, stride*r.Dy()), Stride: stride, Rect: r}
+}
+
+func (img *Gray2) ColorModel() color.Model {
+	return Gray2Model
+}
+
+func (img *Gray2) Bounds() image.Rectangle {
+	return img.Rect
+}
+
+func (img *Gray2) At(x, y int) color.Color {
+	return img.GrayAt(x, y)
+}
+
+func (img *Gray2) RGBA64At(x, y int) color.RGBA64 {
+	v := uint16(img.GrayAt(x, y).Y) * 0x101
+	return color.RGBA64{v, v, v, 0xffff}
+}
+
+func (img *Gray2) GrayAt(x, y int) color.Gray {
+	if !(image.Point{x, y}.In(img.Rect)) {
+		return color.Gray{}
+	}
+	pos := gray2.pos(img.Stride, img.Rect, img.bit, x, y)
+	return color.Gray{Y: expand(gray2.get(img.Pix, pos, 0), 2)}
+}
+
+// PixOffset returns the index of the element of Pix that holds the
+// pixel at (x, y).
+func (img *Gray2) PixOffset(x, y int) int {
+	return gray2.pos(img.Stride, img.Rect, img.bit, x, y) / 8
+}
+
+func (img *Gray2) Set(x, y int, c color.Color) {
+	img.setGray16(x, y, color.Gray16Model.Convert(c).(color.Gray16).Y)
+}
+
+func (img *Gray2) SetRGBA64(x, y int, c color.RGBA64) {
+	img.setGray16(x, y, luminance16(c))
+}
+
+// SetGray sets the pixel at (x, y) to the level nearest to c.
+func (img *Gray2) SetGray(x, y int, c color.Gray) {
+	img.setGray16(x, y, uint16(c.Y)*0x101)
+}
+
+func (img *Gray2) setGray16(x, y int, v uint16) {
+	if !(image.Point{x, y}.In(img.Rect)) {
+		return
+	}
+	pos := gray2.pos(img.Stride, img.Rect, img.bit, x, y)
+	gray2.set(img.Pix, pos, 0, quantize(v, 2))
+}
+
+// SubImage returns an image representing the portion of the image
+// visible through r. The returned value shares pixels with the
+// original image.
+func (img *Gray2) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(img.Rect)
+	if r.Empty() {
+		return &Gray2{}
+	}
+	i, bit := gray2.subImage(img.Stride, img.Rect, r, img.bit)
+	return &Gray2{Pix: img.Pix[i:], Stride: img.Stride, Rect: r, bit: bit}
+}
+
+// Opaque reports whether the image is fully opaque, which it always
+// is.
+func (img *Gray2) Opaque() bool {
+	return true
+}
+
+// Gray4 is an in-memory image of 4-bit gray levels, with 2 pixels
+// packed into one byte. Its At method returns color.Gray values.
+type Gray4 struct {
+	Pix    []uint8
+	Stride int
+	Rect   image.Rectangle
+
+	// bit is the position of the pixel at Rect.Min.X in its byte.
+	bit int
+}
+
+// NewGray4 returns a new, black Gray4 image with the given bounds.
+func NewGray4(r image.Rectangle) *Gray4 {
+	stride := gray4.stride(r.Dx())
+	return &Gray4{Pix: make([]uint8, stride*r.Dy()), Stride: stride, Rect: r}
+}
+
+func (img *Gray4) ColorModel() color.Model {
+	return Gray4Model
+}
+
+func (img *Gray4) Bounds() image.Rectangle {
+	return img.Rect
+}
+
+func (img *Gray4) At(x, y int) color.Color {
+	return img.GrayAt(x, y)
+}
+
+func (img *Gray4) RGBA64At(x, y int) color.RGBA64 {
+	v := uint16(img.GrayAt(x, y).Y) * 0x101
+	return color.RGBA64{v, v, v, 0xffff}
+}
+
+func (img *Gray4) GrayAt(x, y int) color.Gray {
+	if !(image.Point{x, y}.In(img.Rect)) {
+		return color.Gray{}
+	}
+	pos := gray4.pos(img.Stride, img.Rect, img.bit, x, y)
+	return color.Gray{Y: expand(gray4.get(img.Pix, pos, 0), 4)}
+}
+
+// PixOffset returns the index of the element of Pix that holds the
+// pixel at (x, y).
+func (img *Gray4) PixOffset(x, y int) int {
+	return gray4.pos(img.Stride, img.Rect, img.bit, x, y) / 8
+}
+
+func (img *Gray4) Set(x, y int, c color.Color) {
+	img.setGray16(x, y, color.Gray16Model.Convert(c).(color.Gray16).Y)
+}
+
+func (img *Gray4) SetRGBA64(x, y int, c color.RGBA64) {
+	img.setGray16(x, y, luminance16(c))
+}
+
+// SetGray sets the pixel at (x, y) to the level nearest to c.
+func (img *Gray4) SetGray(x, y int, c color.Gray) {
+	img.setGray16(x, y, uint16(c.Y)*0x101)
+}
+
+func (img *Gray4) setGray16(x, y int, v uint16) {
+	if !(image.Point{x, y}.In(img.Rect)) {
+		return
+	}
+	pos := gray4.pos(img.Stride, img.Rect, img.bit, x, y)
+	gray4.set(img.Pix, pos, 0, quantize(v, 4))
+}
+
+// SubImage returns an image representing the portion of the image
+// visible through r. The returned value shares pixels with the
+// original image.
+func (img *Gray4) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(img.Rect)
+	if r.Empty() {
+		return &Gray4{}
+	}
+	i, bit := gray4.subImage(img.Stride, img.Rect, r, img.bit)
+	return &Gray4{Pix: img.Pix[i:], Stride: img.Stride, Rect: r, bit: bit}
+}
+
+// Opaque reports whether the image is fully opaque, which it always
+// is.
+func (img *Gray4) Opaque() bool {
+	return true
+}
+
+// cmykAt returns the color of the pixel at (x, y) of a packed CMYK
+// image.
+func cmykAt(l packed, pix []uint8, stride int, r image.Rectangle, bit, x, y int) color.CMYK {
+	if !(image.Point{x, y}.In(r)) {
+		return color.CMYK{}
+	}
+	pos := l.pos(stride, r, bit, x, y)
+	return color.CMYK{
+		C: expand(l.get(pix, pos, 0), l.bpc),
+		M: expand(l.get(pix, pos, 1), l.bpc),
+		Y: expand(l.get(pix, pos, 2), l.bpc),
+		K: expand(l.get(pix, pos, 3), l.bpc),
+	}
+}
+
+// setCMYK sets the pixel at (x, y) of a packed CMYK image to the
+// levels nearest to c.
+func setCMYK(l packed, pix []uint8, stride int, r image.Rectangle, bit, x, y int, c raster.CMYK64) {
+	if !(image.Point{x, y}.In(r)) {
+		return
+	}
+	pos := l.pos(stride, r, bit, x, y)
+	l.set(pix, pos, 0, quantize(c.C, l.bpc))
+	l.set(pix, pos, 1, quantize(c.M, l.bpc))
+	l.set(pix, pos, 2, quantize(c.Y, l.bpc))
+	l.set(pix, pos, 3, quantize(c.K, l.bpc))
+}
+
+// toCMYK64 converts c like raster.CMYK64Model, but keeps the values
+// of color.CMYK colors.
+func toCMYK64(c color.Color) raster.CMYK64 {
+	if k, ok := c.(color.CMYK); ok {
+		return cmyk8To64(k)
+	}
+	return raster.CMYK64Model.Convert(c).(raster.CMYK64)
+}
+
+func cmyk8To64(c color.CMYK) raster.CMYK64 {
+	return raster.CMYK64{
+		C: uint16(c.C) * 0x101,
+		M: uint16(c.M) * 0x101,
+		Y: uint16(c.Y) * 0x101,
+		K: uint16(c.K) * 0x101,
+	}
+}
+
+func cmykToRGBA64(c color.CMYK) color.RGBA64 {
+	r, g, b, a := c.RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// CMYK1 is an in-memory image of 1-bit CMYK colors, with 2 pixels
+// packed into one byte. Its At method returns color.CMYK values.
+type CMYK1 struct {
+	Pix    []uint8
+	Stride int
+	Rect   image.Rectangle
+
+	// bit is the position of the pixel at Rect.Min.X in its byte.
+	bit int
+}
+
+// NewCMYK1 returns a new, white CMYK1 image with the given bounds.
+func NewCMYK1(r image.Rectangle) *CMYK1 {
+	stride := cmyk1.stride(r.Dx())
+	return &CMYK1{Pix: make([]uint8, stride*r.Dy()), Stride: stride, Rect: r}
+}
+
+func (img *CMYK1) ColorModel() color.Model {
+	return CMYK1Model
+}
+
+func (img *CMYK1) Bounds() image.Rectangle {
+	return img.Rect
+}
+
+func (img *CMYK1) At(x, y int) color.Color {
+	return img.CMYKAt(x, y)
+}
+
+func (img *CMYK1) RGBA64At(x, y int) color.RGBA64 {
+	return cmykToRGBA64(img.CMYKAt(x, y))
+}
+
+func (img *CMYK1) CMYKAt(x, y int) color.CMYK {
+	return cmykAt(cmyk1, img.Pix, img.Stride, img.Rect, img.bit, x, y)
+}
+
+// PixOffset returns the index of the element of Pix that holds the
+// pixel at (x, y).
+func (img *CMYK1) PixOffset(x, y int) int {
+	return cmyk1.pos(img.Stride, img.Rect, img.bit, x, y) / 8
+}
+
+func (img *CMYK1) Set(x, y int, c color.Color) {
+	img.setCMYK64(x, y, toCMYK64(c))
+}
+
+func (img *CMYK1) SetRGBA64(x, y int, c color.RGBA64) {
+	img.setCMYK64(x, y, toCMYK64(c))
+}
+
+// SetCMYK sets the pixel at (x, y) to the levels nearest to c.
+func (img *CMYK1) SetCMYK(x, y int, c color.CMYK) {
+	img.setCMYK64(x, y, cmyk8To64(c))
+}
+
+func (img *CMYK1) setCMYK64(x, y int, c raster.CMYK64) {
+	setCMYK(cmyk1, img.Pix, img.Stride, img.Rect, img.bit, x, y, c)
+}
+
+// SubImage returns an image representing the portion of the image
+// visible through r. The returned value shares pixels with the
+// original image.
+func (img *CMYK1) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(img.Rect)
+	if r.Empty() {
+		return &CMYK1{}
+	}
+	i, bit := cmyk1.subImage(img.Stride, img.Rect, r, img.bit)
+	return &CMYK1{Pix: img.Pix[i:], Stride: img.Stride, Rect: r, bit: bit}
+}
+
+// Opaque reports whether the image is fully opaque, which it always
+// is.
+func (img *CMYK1) Opaque() bool {
+	return true
+}
+
+// CMYK2 is an in-memory image of 2-bit CMYK colors, with one pixel
+// per byte. Its At method returns color.CMYK values.
+type CMYK2 struct {
+	Pix    []uint8
+	Stride int
+	Rect   image.Rectangle
+}
+
+// NewCMYK2 returns a new, white CMYK2 image with the given bounds.
+func NewCMYK2(r image.Rectangle) *CMYK2 {
+	stride := cmyk2.stride(r.Dx())
+	return &CMYK2{Pix: make([]uint8, stride*r.Dy()), Stride: stride, Rect: r}
+}
+
+func (img *CMYK2) ColorModel() color.Model {
+	return CMYK2Model
+}
+
+func (img *CMYK2) Bounds() image.Rectangle {
+	return img.Rect
+}
+
+func (img *CMYK2) At(x, y int) color.Color {
+	return img.CMYKAt(x, y)
+}
+
+func (img *CMYK2) RGBA64At(x, y int) color.RGBA64 {
+	return cmykToRGBA64(img.CMYKAt(x, y))
+}
+
+func (img *CMYK2) CMYKAt(x, y int) color.CMYK {
+	return cmykAt(cmyk2, img.Pix, img.Stride, img.Rect, 0, x, y)
+}
+
+// PixOffset returns the index of the element of Pix that holds the
+// pixel at (x, y).
+func (img *CMYK2) PixOffset(x, y int) int {
+	return cmyk2.pos(img.Stride, img.Rect, 0, x, y) / 8
+}
+
+func (img *CMYK2) Set(x, y int, c color.Color) {
+	img.setCMYK64(x, y, toCMYK64(c))
+}
+
+func (img *CMYK2) SetRGBA64(x, y int, c color.RGBA64) {
+	img.setCMYK64(x, y, toCMYK64(c))
+}
+
+// SetCMYK sets the pixel at (x, y) to the levels nearest to c.
+func (img *CMYK2) SetCMYK(x, y int, c color.CMYK) {
+	img.setCMYK64(x, y, cmyk8To64(c))
+}
+
+func (img *CMYK2) setCMYK64(x, y int, c raster.CMYK64) {
+	setCMYK(cmyk2, img.Pix, img.Stride, img.Rect, 0, x, y, c)
+}
+
+// SubImage returns an image representing the portion of the image
+// visible through r. The returned value shares pixels with the
+// original image.
+func (img *CMYK2) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(img.Rect)
+	if r.Empty() {
+		return &CMYK2{}
+	}
+	i := img.PixOffset(r.Min.X, r.Min.Y)
+	return &CMYK2{Pix: img.Pix[i:], Stride: img.Stride, Rect: r}
+}
+
+// Opaque reports whether the image is fully opaque, which it always
+// is.
+func (img *CMYK2) Opaque() bool {
+	return true
+}
+
+// CMYK4 is an in-memory image of 4-bit CMYK colors, with one pixel
+// per two bytes. Its At method returns color.CMYK values.
+type CMYK4 struct {
+	Pix    []uint8
+	Stride int
+	Rect   image.Rectangle
+}
+
+// NewCMYK4 returns a new, white CMYK4 image with the given bounds.
+func NewCMYK4(r image.Rectangle) *CMYK4 {
+	stride := cmyk4.stride(r.Dx())
+	return &CMYK4{Pix: make([]uint8, stride*r.Dy()), Stride: stride, Rect: r}
+}
+
+func (img *CMYK4) ColorModel() color.Model {
+	return CMYK4Model
+}
+
+func (img *CMYK4) Bounds() image.Rectangle {
+	return img.Rect
+}
+
+func (img *CMYK4) At(x, y int) color.Color {
+	return img.CMYKAt(x, y)
+}
+
+func (img *CMYK4) RGBA64At(x, y int) color.RGBA64 {
+	return cmykToRGBA64(img.CMYKAt(x, y))
+}
+
+func (img *CMYK4) CMYKAt(x, y int) color.CMYK {
+	return cmykAt(cmyk4, img.Pix, img.Stride, img.Rect, 0, x, y)
+}
+
+// PixOffset returns the index of the first element of Pix that
+// corresponds to the pixel at (x, y).
+func (img *CMYK4) PixOffset(x, y int) int {
+	return cmyk4.pos(img.Stride, img.Rect, 0, x, y) / 8
+}
+
+func (img *CMYK4) Set(x, y int, c color.Color) {
+	img.setCMYK64(x, y, toCMYK64(c))
+}
+
+func (img *CMYK4) SetRGBA64(x, y int, c color.RGBA64) {
+	img.setCMYK64(x, y, toCMYK64(c))
+}
+
+// SetCMYK sets the pixel at (x, y) to the levels nearest to c.
+func (img *CMYK4) SetCMYK(x, y int, c color.CMYK) {
+	img.setCMYK64(x, y, cmyk8To64(c))
+}
+
+func (img *CMYK4) setCMYK64(x, y int, c raster.CMYK64) {
+	setCMYK(cmyk4, img.Pix, img.Stride, img.Rect, 0, x, y, c)
+}
+
+// SubImage returns an image representing the portion of the image
+// visible through r. The returned value shares pixels with the
+// original image.
+func (img *CMYK4) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(img.Rect)
+	if r.Empty() {
+		return &CMYK4{}
+	}
+	i := img.PixOffset(r.Min.X, r.Min.Y)
+	return &CMYK4{Pix: img.Pix[i:], Stride: img.Stride, Rect: r}
+}
+
+// Opaque reports whether the image is fully opaque, which it always
+// is.
+func (img *CMYK4) Opaque() bool {
+	return true
+}
@@ -0,0 +1,202 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"honnef.co/go/cups/raster"
+)
+
+// packedImage is implemented by the packed image types.
+type packedImage interface {
+	image.Image
+	Set(x, y int, c color.Color)
+	SubImage(r image.Rectangle) image.Image
+}
+
+var packedTypes = []struct {
+	name string
+	new  func(r image.Rectangle) packedImage
+}{
+	{"Gray2", func(r image.Rectangle) packedImage { return NewGray2(r) }},
+	{"Gray4", func(r image.Rectangle) packedImage { return NewGray4(r) }},
+	{"CMYK1", func(r image.Rectangle) packedImage { return NewCMYK1(r) }},
+	{"CMYK2", func(r image.Rectangle) packedImage { return NewCMYK2(r) }},
+	{"CMYK4", func(r image.Rectangle) packedImage { return NewCMYK4(r) }},
+}
+
+// testColor returns a color that differs for most pixels.
+func testColor(x, y int) color.Color {
+	if (x+y)%3 == 0 {
+		return color.CMYK{C: uint8(x * 37), M: uint8(y * 91), Y: uint8(x * y * 13), K: uint8(x * 7)}
+	}
+	return color.RGBA{R: uint8(x * 53), G: uint8(y * 29), B: uint8((x + y) * 71), A: 255}
+}
+
+func TestPackedAtSet(t *testing.T) {
+	r := image.Rect(1, 2, 10, 5)
+	for _, typ := range packedTypes {
+		img := typ.new(r)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				img.Set(x, y, testColor(x, y))
+			}
+		}
+		// Pixels outside of the image are ignored.
+		img.Set(r.Max.X, r.Min.Y, color.Black)
+		img.Set(r.Min.X-1, r.Min.Y, color.Black)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				want := img.ColorModel().Convert(testColor(x, y))
+				if got := img.At(x, y); got != want {
+					t.Errorf("%s: pixel (%d, %d) is %v, want %v", typ.name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestPackedSubImage(t *testing.T) {
+	r := image.Rect(1, 2, 10, 5)
+	for _, typ := range packedTypes {
+		img := typ.new(r)
+		outside := img.At(9, 3)
+		// Sub-images at every possible bit offset, nested once.
+		for x0 := r.Min.X; x0 < r.Min.X+4; x0++ {
+			sub := img.SubImage(image.Rect(x0, 3, 9, 7)).(packedImage)
+			if want := image.Rect(x0, 3, 9, 5); sub.Bounds() != want {
+				t.Fatalf("%s: got bounds %v, want %v", typ.name, sub.Bounds(), want)
+			}
+			subsub := sub.SubImage(image.Rect(x0+1, 4, 8, 5)).(packedImage)
+			for y := 3; y < 5; y++ {
+				for x := x0; x < 9; x++ {
+					c := testColor(x+x0, y)
+					sub.Set(x, y, c)
+					want := img.ColorModel().Convert(c)
+					if got := img.At(x, y); got != want {
+						t.Errorf("%s: setting (%d, %d) of a sub-image at x=%d changed it to %v, want %v", typ.name, x, y, x0, got, want)
+					}
+					if (image.Point{x, y}.In(subsub.Bounds())) && subsub.At(x, y) != want {
+						t.Errorf("%s: pixel (%d, %d) of a nested sub-image is %v, want %v", typ.name, x, y, subsub.At(x, y), want)
+					}
+				}
+			}
+			// Pixels outside of the sub-image aren't affected.
+			if got := img.At(9, 3); got != outside {
+				t.Errorf("%s: pixel (9, 3) outside of the sub-image changed to %v", typ.name, got)
+			}
+		}
+		if !img.SubImage(image.Rect(20, 20, 30, 30)).Bounds().Empty() {
+			t.Errorf("%s: sub-image outside of the image isn't empty", typ.name)
+		}
+	}
+}
+
+func TestViewMatchesParseColors(t *testing.T) {
+	tests := []struct {
+		cs       raster.ColorSpace
+		bpc, bpp int
+		want     string
+	}{
+		{raster.ColorSpaceBlack, 1, 1, "*image.Monochrome"},
+		{raster.ColorSpaceGray, 2, 2, "*image.Gray2"},
+		{raster.ColorSpacesGray, 4, 4, "*image.Gray4"},
+		{raster.ColorSpaceBlack, 2, 2, "*image.Gray2"},
+		{raster.ColorSpaceBlack, 4, 4, "*image.Gray4"},
+		{raster.ColorSpaceCMYK, 1, 4, "*image.CMYK1"},
+		{raster.ColorSpaceCMYK, 2, 8, "*image.CMYK2"},
+		{raster.ColorSpaceCMYK, 4, 16, "*image.CMYK4"},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		h := &raster.Header{HorizDPI: 72, VertDPI: 72}
+		h.CUPS.Width = 7
+		h.CUPS.Height = 3
+		h.CUPS.ColorSpace = tt.cs
+		h.CUPS.BitsPerColor = tt.bpc
+		h.CUPS.BitsPerPixel = tt.bpp
+		h.CUPS.BytesPerLine = (7*tt.bpp + 7) / 8
+		lines := make([][]byte, h.CUPS.Height)
+		for i := range lines {
+			lines[i] = make([]byte, h.CUPS.BytesPerLine)
+			rng.Read(lines[i])
+		}
+		img, err := Image(newPage(h, lines, t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%T", img); got != tt.want {
+			t.Errorf("%v, %d bits: got %s, want %s", tt.cs, tt.bpc, got, tt.want)
+			continue
+		}
+		p := newPage(h, lines, t)
+		b := make([]byte, h.CUPS.BytesPerLine)
+		for y := 0; y < h.CUPS.Height; y++ {
+			if err := p.ReadLine(b); err != nil {
+				t.Fatal(err)
+			}
+			colors, err := p.ParseColors(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for x := 0; x < h.CUPS.Width; x++ {
+				r1, g1, b1, a1 := img.At(x, y).RGBA()
+				r2, g2, b2, a2 := colors[x].RGBA()
+				if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+					t.Errorf("%v, %d bits: pixel (%d, %d) is %v, ParseColors returned %v", tt.cs, tt.bpc, x, y, img.At(x, y), colors[x])
+				}
+			}
+		}
+	}
+}
+
+func TestQuantizeMatchesRasterizer(t *testing.T) {
+	// A gradient over all 16-bit values, in steps small enough to hit
+	// the rounding boundaries of every level.
+	src := image.NewGray16(image.Rect(0, 0, 4096, 1))
+	for x := 0; x < 4096; x++ {
+		src.SetGray16(x, 0, color.Gray16{Y: uint16(x*16 + x%16)})
+	}
+	for _, tt := range []struct {
+		cs    raster.ColorSpace
+		bpc   int
+		model color.Model
+	}{
+		{raster.ColorSpaceGray, 2, Gray2Model},
+		{raster.ColorSpaceGray, 4, Gray4Model},
+		{raster.ColorSpaceCMYK, 1, CMYK1Model},
+		{raster.ColorSpaceCMYK, 2, CMYK2Model},
+		{raster.ColorSpaceCMYK, 4, CMYK4Model},
+	} {
+		h := &raster.Header{HorizDPI: 72, VertDPI: 72}
+		h.CUPS.ColorSpace = tt.cs
+		h.CUPS.BitsPerColor = tt.bpc
+		var buf bytes.Buffer
+		e, err := raster.NewEncoder(&buf, 3, binary.BigEndian)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Encode(e, src, h); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		img, err := Image(decodePage(buf.Bytes(), t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for x := 0; x < 4096; x++ {
+			want := tt.model.Convert(src.At(x, 0))
+			if got := img.At(x, 0); got != want {
+				t.Errorf("%v, %d bits: %v was rasterized as %v, but the model converts it to %v", tt.cs, tt.bpc, src.At(x, 0), got, want)
+				break
+			}
+		}
+	}
+}
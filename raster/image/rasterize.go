@@ -0,0 +1,321 @@
+package image
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"honnef.co/go/cups/raster"
+)
+
+// A Rasterizer converts an image.Image to the image data of a raster
+// page, performing color conversion and bit packing. Its lines can be
+// read with ReadLine, like those of a raster.Page, and written to a
+// raster.Encoder, or be used as raw line buffers.
+//
+// Colors are converted with the color models of image/color and of
+// the raster package. Colors that aren't fully opaque are composited
+// onto white paper, except for ColorSpaceRGBA, which stores alpha.
+// CMYK colors of the image, such as those of *image.CMYK, are stored
+// without converting them via RGB. Samples with fewer than 16 bits
+// are rounded to the nearest level; no dithering is done.
+type Rasterizer struct {
+	// Header is the header of the page, as passed to
+	// NewRasterizer, but with the fields describing the layout of
+	// the image data filled in.
+	Header *raster.Header
+
+	img       image.Image
+	bo        binary.ByteOrder
+	n         int
+	linesRead int
+	// samples holds the 16-bit samples of the current line, n per
+	// pixel.
+	samples []uint16
+	// row is the line of the image that samples holds, or -1.
+	row int
+}
+
+// NewRasterizer returns a Rasterizer that converts img to a page
+// with the header h. The color space, bits per color and color order
+// of h determine the format of the image data. If h.CUPS.Width and
+// h.CUPS.Height are zero, the size of img is used. The image is
+// aligned with the top left corner of the page; pixels outside of
+// its bounds are white. NumColors, BitsPerPixel and BytesPerLine are
+// computed. Like CUPS, ChunkyPixels pages pad 3-color pixels with
+// fewer than 8 bits per color to 4 colors, and the 6 colors of 1-bit
+// KCMYcm pixels to 8 bits, with the padding preceding the colors. h
+// isn't modified.
+//
+// Samples with 16 bits are stored in byte order bo, which must match
+// the byte order of the stream that the page is written to.
+//
+// NewRasterizer returns raster.ErrUnsupported for the ICC and Device
+// color spaces, whose colorants are unknown, and a
+// *raster.HeaderError if the resulting header is invalid.
+func NewRasterizer(img image.Image, h *raster.Header, bo binary.ByteOrder) (*Rasterizer, error) {
+	nh := *h
+	c := &nh.CUPS
+	if c.Width == 0 && c.Height == 0 {
+		c.Width = img.Bounds().Dx()
+		c.Height = img.Bounds().Dy()
+	}
+	n := c.ColorSpace.ColorsPerPixel(c.BitsPerColor)
+	if n == 0 || (c.ColorSpace >= raster.ColorSpaceICC1 && c.ColorSpace <= raster.ColorSpaceICCF) ||
+		(c.ColorSpace >= raster.ColorSpaceDevice1 && c.ColorSpace <= raster.ColorSpaceDeviceF) {
+		return nil, raster.ErrUnsupported
+	}
+	bpc := c.BitsPerColor
+	c.NumColors = n
+	switch c.ColorOrder {
+	case raster.ChunkyPixels:
+		c.BitsPerPixel = n * bpc
+		switch {
+		case n == 3 && bpc < 8:
+			c.BitsPerPixel = 4 * bpc
+		case n == 6:
+			c.BitsPerPixel = 8
+		}
+		c.BytesPerLine = (c.Width*c.BitsPerPixel + 7) / 8
+	case raster.BandedPixels:
+		c.BitsPerPixel = bpc
+		c.BytesPerLine = (c.Width*bpc + 7) / 8 * n
+	case raster.PlanarPixels:
+		c.BitsPerPixel = bpc
+		c.BytesPerLine = (c.Width*bpc + 7) / 8
+	}
+	if errs := nh.Validate(); errs != nil {
+		return nil, &raster.HeaderError{Problems: errs}
+	}
+	return &Rasterizer{
+		Header:  &nh,
+		img:     img,
+		bo:      bo,
+		n:       n,
+		samples: make([]uint16, n*c.Width),
+		row:     -1,
+	}, nil
+}
+
+// UnreadLines returns the number of lines that haven't been read
+// yet. Pages with PlanarPixels consist of h.CUPS.Height lines per
+// color.
+func (r *Rasterizer) UnreadLines() int {
+	return r.lines() - r.linesRead
+}
+
+func (r *Rasterizer) lines() int {
+	if r.Header.CUPS.ColorOrder == raster.PlanarPixels {
+		return r.Header.CUPS.Height * r.n
+	}
+	return r.Header.CUPS.Height
+}
+
+// ReadLine stores the next line of image data in b, which must be at
+// least Header.CUPS.BytesPerLine bytes large. It returns io.EOF after
+// the last line.
+func (r *Rasterizer) ReadLine(b []byte) error {
+	c := &r.Header.CUPS
+	if len(b) < c.BytesPerLine {
+		return raster.ErrBufferTooSmall
+	}
+	if r.linesRead >= r.lines() {
+		return io.EOF
+	}
+	y, plane := r.linesRead, -1
+	if c.ColorOrder == raster.PlanarPixels {
+		y, plane = r.linesRead%c.Height, r.linesRead/c.Height
+	}
+	r.linesRead++
+	if y != r.row {
+		r.convert(y)
+	}
+
+	b = b[:c.BytesPerLine]
+	for i := range b {
+		b[i] = 0
+	}
+	bpc := c.BitsPerColor
+	switch c.ColorOrder {
+	case raster.ChunkyPixels:
+		pad := c.BitsPerPixel - r.n*bpc
+		for x := 0; x < c.Width; x++ {
+			off := x*c.BitsPerPixel + pad
+			for i, v := range r.samples[x*r.n : (x+1)*r.n] {
+				r.put(b, off+i*bpc, v)
+			}
+		}
+	case raster.BandedPixels:
+		band := c.BytesPerLine / r.n
+		for i := 0; i < r.n; i++ {
+			r.putPlane(b[i*band:(i+1)*band], i)
+		}
+	case raster.PlanarPixels:
+		r.putPlane(b, plane)
+	}
+	return nil
+}
+
+// putPlane stores the i-th color of every pixel of the current line
+// in b.
+func (r *Rasterizer) putPlane(b []byte, i int) {
+	bpc := r.Header.CUPS.BitsPerColor
+	for x := 0; x < r.Header.CUPS.Width; x++ {
+		r.put(b, x*bpc, r.samples[x*r.n+i])
+	}
+}
+
+// put stores the 16-bit sample v at bit offset off in b, which must
+// be zeroed, reducing it to the page's bits per color.
+func (r *Rasterizer) put(b []byte, off int, v uint16) {
+	bpc := r.Header.CUPS.BitsPerColor
+	if bpc == 16 {
+		r.bo.PutUint16(b[off/8:], v)
+		return
+	}
+	b[off/8] |= quantize(v, uint(bpc)) << uint(8-off%8-bpc)
+}
+
+// cmykImage is implemented by images that store CMYK colors, such as
+// *image.CMYK and *CMYK1.
+type cmykImage interface {
+	CMYKAt(x, y int) color.CMYK
+}
+
+// convert stores the samples of the y-th line of the page in
+// r.samples.
+func (r *Rasterizer) convert(y int) {
+	r.row = y
+	c := &r.Header.CUPS
+	bounds := r.img.Bounds()
+	cimg, isCMYK := r.img.(cmykImage)
+	for x := 0; x < c.Width; x++ {
+		px, py := bounds.Min.X+x, bounds.Min.Y+y
+		s := r.samples[x*r.n : (x+1)*r.n]
+		if !(image.Point{px, py}.In(bounds)) {
+			// Images don't agree on the color outside of their
+			// bounds; *image.Gray returns black.
+			r.fromRGBA(s, color.RGBA64{0xffff, 0xffff, 0xffff, 0xffff})
+			continue
+		}
+		if isCMYK {
+			k := cimg.CMYKAt(px, py)
+			if r.fromCMYK(s, raster.CMYK64{
+				C: uint16(k.C) * 0x101,
+				M: uint16(k.M) * 0x101,
+				Y: uint16(k.Y) * 0x101,
+				K: uint16(k.K) * 0x101,
+			}) {
+				continue
+			}
+		}
+		r.fromRGBA(s, rgba64At(r.img, px, py))
+	}
+}
+
+// rgba64At returns the color of the pixel at (x, y) of img, avoiding
+// allocations if possible.
+func rgba64At(img image.Image, x, y int) color.RGBA64 {
+	if img, ok := img.(image.RGBA64Image); ok {
+		return img.RGBA64At(x, y)
+	}
+	r, g, b, a := img.At(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// fromCMYK stores the samples of k in s. It reports false if the
+// color space is not a CMYK color space.
+func (r *Rasterizer) fromCMYK(s []uint16, k raster.CMYK64) bool {
+	switch r.Header.CUPS.ColorSpace {
+	case raster.ColorSpaceCMYK:
+		s[0], s[1], s[2], s[3] = k.C, k.M, k.Y, k.K
+	case raster.ColorSpaceYMCK, raster.ColorSpaceGMCK, raster.ColorSpaceGMCS:
+		s[0], s[1], s[2], s[3] = k.Y, k.M, k.C, k.K
+	case raster.ColorSpaceKCMY:
+		s[0], s[1], s[2], s[3] = k.K, k.C, k.M, k.Y
+	case raster.ColorSpaceKCMYcm:
+		if r.n != 6 {
+			s[0], s[1], s[2], s[3] = k.K, k.C, k.M, k.Y
+			break
+		}
+		ink := func(b bool) uint16 {
+			if b {
+				return 0xffff
+			}
+			return 0
+		}
+		v := raster.KCMYcmModel.Convert(k).(raster.KCMYcm)
+		s[0], s[1], s[2], s[3], s[4], s[5] = ink(v.K), ink(v.C), ink(v.M), ink(v.Y), ink(v.LC), ink(v.LM)
+	default:
+		return false
+	}
+	return true
+}
+
+// fromRGBA stores the samples of the alpha-premultiplied color c in
+// s.
+func (r *Rasterizer) fromRGBA(s []uint16, c color.RGBA64) {
+	cs := r.Header.CUPS.ColorSpace
+	if cs == raster.ColorSpaceRGBA {
+		n := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+		s[0], s[1], s[2], s[3] = n.R, n.G, n.B, n.A
+		return
+	}
+	// Composite onto white.
+	w := 0xffff - uint32(c.A)
+	cr, cg, cb := uint32(c.R)+w, uint32(c.G)+w, uint32(c.B)+w
+	opaque := color.RGBA64{uint16(cr), uint16(cg), uint16(cb), 0xffff}
+	switch cs {
+	case raster.ColorSpaceGray, raster.ColorSpacesGray:
+		s[0] = luminance16(opaque)
+	case raster.ColorSpaceBlack, raster.ColorSpaceWHITE, raster.ColorSpaceGOLD, raster.ColorSpaceSILVER:
+		s[0] = 0xffff - luminance16(opaque)
+	case raster.ColorSpaceRGB, raster.ColorSpacesRGB, raster.ColorSpaceAdobeRGB:
+		s[0], s[1], s[2] = opaque.R, opaque.G, opaque.B
+	case raster.ColorSpaceRGBW:
+		v := raster.RGBW64Model.Convert(opaque).(raster.RGBW64)
+		s[0], s[1], s[2], s[3] = v.R, v.G, v.B, v.W
+	case raster.ColorSpaceCMY:
+		s[0], s[1], s[2] = 0xffff-opaque.R, 0xffff-opaque.G, 0xffff-opaque.B
+	case raster.ColorSpaceYMC:
+		s[0], s[1], s[2] = 0xffff-opaque.B, 0xffff-opaque.G, 0xffff-opaque.R
+	case raster.ColorSpaceCIEXYZ:
+		// CUPS scales XYZ values from [0, 1.1] to [0, 65535]
+		v := raster.CIEXYZModel.Convert(opaque).(raster.CIEXYZ)
+		s[0], s[1], s[2] = clamp16(v.X*59577.2727), clamp16(v.Y*59577.2727), clamp16(v.Z*59577.2727)
+	case raster.ColorSpaceCIELab:
+		v := raster.CIELabModel.Convert(opaque).(raster.CIELab)
+		s[0], s[1], s[2] = clamp16(v.L*655.35), clamp16((v.A+128)*256), clamp16((v.B+128)*256)
+	default:
+		r.fromCMYK(s, raster.CMYK64Model.Convert(opaque).(raster.CMYK64))
+	}
+}
+
+// clamp16 rounds v to the nearest 16-bit value.
+func clamp16(v float64) uint16 {
+	return uint16(math.Max(0, math.Min(0xffff, v+0.5)))
+}
+
+// Encode writes img as a new page with the header h to e, converting
+// it as described by NewRasterizer.
+func Encode(e *raster.Encoder, img image.Image, h *raster.Header) error {
+	r, err := NewRasterizer(img, h, e.ByteOrder())
+	if err != nil {
+		return err
+	}
+	if err := e.WritePage(r.Header); err != nil {
+		return err
+	}
+	b := make([]byte, r.Header.CUPS.BytesPerLine)
+	for r.UnreadLines() > 0 {
+		if err := r.ReadLine(b); err != nil {
+			return err
+		}
+		if err := e.WriteLine(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
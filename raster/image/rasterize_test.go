@@ -0,0 +1,197 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+
+	"honnef.co/go/cups/raster"
+)
+
+// rasterizeTestImage returns an image of black, white and the
+// primary and secondary colors, which all color spaces can represent
+// at any bit depth.
+func rasterizeTestImage() *image.RGBA {
+	colors := []color.RGBA{
+		{0, 0, 0, 255},
+		{255, 255, 255, 255},
+		{255, 0, 0, 255},
+		{0, 255, 0, 255},
+		{0, 0, 255, 255},
+		{0, 255, 255, 255},
+		{255, 0, 255, 255},
+		{255, 255, 0, 255},
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 11, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 11; x++ {
+			img.SetRGBA(x, y, colors[(x+y*3)%len(colors)])
+		}
+	}
+	return img
+}
+
+func TestRasterizeRoundTrip(t *testing.T) {
+	colorSpaces := []raster.ColorSpace{
+		raster.ColorSpaceGray,
+		raster.ColorSpacesGray,
+		raster.ColorSpaceBlack,
+		raster.ColorSpaceWHITE,
+		raster.ColorSpaceGOLD,
+		raster.ColorSpaceSILVER,
+		raster.ColorSpaceRGB,
+		raster.ColorSpacesRGB,
+		raster.ColorSpaceAdobeRGB,
+		raster.ColorSpaceRGBA,
+		raster.ColorSpaceCMY,
+		raster.ColorSpaceYMC,
+		raster.ColorSpaceCMYK,
+		raster.ColorSpaceYMCK,
+		raster.ColorSpaceKCMY,
+		raster.ColorSpaceKCMYcm,
+		raster.ColorSpaceGMCK,
+		raster.ColorSpaceGMCS,
+		raster.ColorSpaceRGBW,
+		raster.ColorSpaceCIEXYZ,
+		raster.ColorSpaceCIELab,
+	}
+	src := rasterizeTestImage()
+	for _, cs := range colorSpaces {
+		gray := cs.NumColors() == 1
+		cie := cs == raster.ColorSpaceCIEXYZ || cs == raster.ColorSpaceCIELab
+		for _, bpc := range []int{1, 2, 4, 8, 16} {
+			if cie && bpc < 16 {
+				// With fewer bits, the rounding of CIE colors
+				// moves saturated colors far from their sRGB
+				// values.
+				continue
+			}
+			// Allow for the 8-bit colors of some image types.
+			tol := 0x101
+			switch {
+			case gray && bpc < 16:
+				// The luminance of src is rounded to the nearest
+				// level.
+				tol += 0xffff / (1<<uint(bpc) - 1) / 2
+			case cie:
+				// Converting to and from CIE colors isn't exact.
+				tol = 0x400
+			}
+			for _, order := range []raster.ColorOrder{raster.ChunkyPixels, raster.BandedPixels, raster.PlanarPixels} {
+				for _, bo := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+					h := &raster.Header{HorizDPI: 72, VertDPI: 72}
+					h.CUPS.ColorSpace = cs
+					h.CUPS.BitsPerColor = bpc
+					h.CUPS.ColorOrder = order
+					var buf bytes.Buffer
+					e, err := raster.NewEncoder(&buf, 2, bo)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := Encode(e, src, h); err != nil {
+						t.Errorf("%v, %d bits, %v: %v", cs, bpc, order, err)
+						continue
+					}
+					if err := e.Close(); err != nil {
+						t.Fatal(err)
+					}
+					p := decodePage(buf.Bytes(), t)
+					if errs := p.Header.Validate(); errs != nil {
+						t.Errorf("%v, %d bits, %v: encoded an invalid header: %v", cs, bpc, order, errs)
+					}
+					img, err := Image(p)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if img.Bounds() != src.Bounds() {
+						t.Fatalf("%v, %d bits, %v: got bounds %v, want %v", cs, bpc, order, img.Bounds(), src.Bounds())
+					}
+					checkRoundTrip(t, src, img, gray, tol)
+				}
+			}
+		}
+	}
+}
+
+// checkRoundTrip compares the colors of img with those of src, which
+// may differ by up to tol. For gray color spaces, the luminance of src
+// is compared.
+func checkRoundTrip(t *testing.T, src *image.RGBA, img image.Image, gray bool, tol int) {
+	t.Helper()
+	near := func(a uint32, b uint32) bool {
+		d := int(a) - int(b)
+		return d >= -tol && d <= tol
+	}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 11; x++ {
+			want := src.RGBA64At(x, y)
+			if gray {
+				l := luminance16(want)
+				want = color.RGBA64{l, l, l, 0xffff}
+			}
+			r, g, b, a := img.At(x, y).RGBA()
+			if !near(r, uint32(want.R)) || !near(g, uint32(want.G)) || !near(b, uint32(want.B)) || a != uint32(want.A) {
+				t.Errorf("%T: pixel (%d, %d) is %v, want %v", img, x, y, img.At(x, y), want)
+				return
+			}
+		}
+	}
+}
+
+func TestRasterizeKCMYcm(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	img.Set(0, 0, color.Black)
+	img.Set(1, 0, color.RGBA{255, 0, 0, 255})
+	img.Set(2, 0, color.White)
+	h := &raster.Header{}
+	h.CUPS.ColorSpace = raster.ColorSpaceKCMYcm
+	h.CUPS.BitsPerColor = 1
+	r, err := NewRasterizer(img, h, binary.BigEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := r.Header.CUPS; c.NumColors != 6 || c.BitsPerPixel != 8 || c.BytesPerLine != 3 {
+		t.Errorf("got %d colors, %d bits per pixel and %d bytes per line, want 6, 8 and 3", c.NumColors, c.BitsPerPixel, c.BytesPerLine)
+	}
+	b := make([]byte, 3)
+	if err := r.ReadLine(b); err != nil {
+		t.Fatal(err)
+	}
+	// Two bits of padding, then K, C, M, Y, LC and LM.
+	if want := []byte{0x20, 0x0C, 0x00}; !bytes.Equal(b, want) {
+		t.Errorf("got %08b, want %08b", b, want)
+	}
+}
+
+func TestRasterizePadding(t *testing.T) {
+	gray := image.NewGray(image.Rect(2, 1, 5, 3))
+	mono := NewMonochrome(image.Rect(2, 1, 5, 3))
+	for i := range mono.Pix {
+		mono.Pix[i] = 0xFF
+	}
+	for _, img := range []image.Image{gray, mono} {
+		h := &raster.Header{}
+		h.CUPS.Width = 4
+		h.CUPS.Height = 3
+		h.CUPS.ColorSpace = raster.ColorSpaceGray
+		h.CUPS.BitsPerColor = 8
+		r, err := NewRasterizer(img, h, binary.BigEndian)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The image is black; the column and line beyond its bounds
+		// are white.
+		want := [][]byte{{0, 0, 0, 255}, {0, 0, 0, 255}, {255, 255, 255, 255}}
+		for y, want := range want {
+			b := make([]byte, 4)
+			if err := r.ReadLine(b); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, want) {
+				t.Errorf("%T: line %d is %v, want %v", img, y, b, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package image
+
+import (
+	"errors"
+	"image"
+	"image/color"
+
+	"honnef.co/go/cups/raster"
+)
+
+// ErrLineEvicted is recorded by Stream when a line is accessed that
+// is no longer in its window.
+var ErrLineEvicted = errors.New("line no longer cached")
+
+// DefaultWindow is the number of lines a Stream caches if no other
+// size is requested.
+const DefaultWindow = 16
+
+// A Stream is an image.Image of a page that decodes the page's lines
+// on demand, as they are accessed by At. Only a window of the most
+// recently decoded lines is kept in memory, which allows consumers
+// that visit pixels in raster order, such as png.Encode, to process
+// very large pages in constant memory.
+//
+// Lines may be accessed in any order, as long as they are still in
+// the window: accessing a line below the window decodes all lines up
+// to it, evicting older ones. Accessing an evicted line returns the
+// zero color and records ErrLineEvicted, as does an error decoding
+// the page. Err reports the first such error, which callers should
+// check after they are done with the image.
+//
+// The colors of a Stream are the same as those of the image returned
+// by Image for the same page. Its bounds are the same as well.
+//
+// Pages with PlanarPixels are the exception to constant memory use:
+// as the colors of a pixel are spread across the entire page, the
+// first access reads the remainder of the page into memory.
+//
+// Because At decodes lines and updates the window, a Stream is not
+// safe for concurrent use, not even by readers only.
+type Stream struct {
+	p    *raster.Page
+	rect image.Rectangle
+	win  image.Image
+	// next decodes the next line of the page into the given line
+	// of win.
+	next  func(y int) error
+	lines int
+	n     int
+	// failed is set once decoding the page has failed.
+	failed bool
+	err    error
+}
+
+// NewStream returns a Stream of the page that caches up to window
+// lines. If window is zero or negative, DefaultWindow is used.
+//
+// Like Image, NewStream consumes the page: no calls to ReadLine or
+// ReadAll must be made before or after calling it. The page is read
+// as the image is accessed.
+func NewStream(p *raster.Page, window int) *Stream {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	r := rect(p)
+	if h := r.Dy(); window > h {
+		window = h
+	}
+	s := &Stream{
+		p:    p,
+		rect: r,
+		n:    window,
+	}
+	w := image.Rect(r.Min.X, 0, r.Max.X, window)
+	bpl := p.Header.CUPS.BytesPerLine
+	buf := make([]byte, bpl*window)
+	if img, invert := view(p, buf, w); img != nil {
+		s.win = img
+		s.next = func(y int) error {
+			line := buf[y*bpl : (y+1)*bpl]
+			if err := p.ReadLine(line); err != nil {
+				return err
+			}
+			if invert != nil {
+				invert(line)
+			}
+			return nil
+		}
+	} else {
+		s.win, s.next = newConverter(p, w)
+	}
+	return s
+}
+
+// ColorModel implements image.Image.
+func (s *Stream) ColorModel() color.Model {
+	return s.win.ColorModel()
+}
+
+// Bounds implements image.Image.
+func (s *Stream) Bounds() image.Rectangle {
+	return s.rect
+}
+
+// At implements image.Image.
+func (s *Stream) At(x, y int) color.Color {
+	if y, ok := s.line(x, y); ok {
+		return s.win.At(x, y)
+	}
+	return s.win.ColorModel().Convert(color.Transparent)
+}
+
+// RGBA64At returns the color of the pixel at (x, y), like At, but
+// without allocating.
+func (s *Stream) RGBA64At(x, y int) color.RGBA64 {
+	if y, ok := s.line(x, y); ok {
+		if img, ok := s.win.(image.RGBA64Image); ok {
+			return img.RGBA64At(x, y)
+		}
+		r, g, b, a := s.win.At(x, y).RGBA()
+		return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+	}
+	return color.RGBA64{}
+}
+
+// Opaque reports whether the image is known to be fully opaque, which
+// is the case for all pages without an alpha channel. Unlike the
+// Opaque methods of the image types in the standard library, it does
+// not scan the image, as that would decode the entire page.
+func (s *Stream) Opaque() bool {
+	switch s.win.(type) {
+	case *image.NRGBA, *image.NRGBA64:
+		return false
+	default:
+		return true
+	}
+}
+
+// Err returns the first error encountered while decoding the page or
+// accessing evicted lines.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// line decodes the line containing (x, y) if necessary, and returns
+// its position in the window. It reports false if the point is
+// outside the image or its line is not available.
+func (s *Stream) line(x, y int) (int, bool) {
+	if !(image.Point{x, y}.In(s.rect)) {
+		return 0, false
+	}
+	y -= s.rect.Min.Y
+	for s.lines <= y {
+		if s.failed {
+			return 0, false
+		}
+		if err := s.next(s.lines % s.n); err != nil {
+			s.failed = true
+			if s.err == nil {
+				s.err = err
+			}
+			return 0, false
+		}
+		s.lines++
+	}
+	if y < s.lines-s.n {
+		if s.err == nil {
+			s.err = ErrLineEvicted
+		}
+		return 0, false
+	}
+	return y % s.n, true
+}
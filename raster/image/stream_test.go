@@ -0,0 +1,141 @@
+package image
+
+import (
+	"encoding/binary"
+	"errors"
+	"image/color"
+	"io"
+	"math/rand"
+	"testing"
+
+	"honnef.co/go/cups/raster"
+)
+
+func TestStream(t *testing.T) {
+	tests := []struct {
+		cs    raster.ColorSpace
+		order raster.ColorOrder
+		bpc   int
+	}{
+		{raster.ColorSpaceBlack, raster.ChunkyPixels, 1},
+		{raster.ColorSpaceGray, raster.ChunkyPixels, 4},
+		{raster.ColorSpaceBlack, raster.ChunkyPixels, 8},
+		{raster.ColorSpaceRGB, raster.ChunkyPixels, 8},
+		{raster.ColorSpaceRGB, raster.BandedPixels, 16},
+		{raster.ColorSpaceCMYK, raster.PlanarPixels, 8},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		h := &raster.Header{HorizDPI: 72, VertDPI: 72}
+		h.CUPS.Width = 5
+		h.CUPS.Height = 9
+		h.CUPS.ColorSpace = tt.cs
+		h.CUPS.ColorOrder = tt.order
+		h.CUPS.BitsPerColor = tt.bpc
+		h.CUPS.ImagingBBox = raster.CUPSBoundingBox{Left: 2, Bottom: 3, Right: 7, Top: 12}
+		h.CUPS.PageSize = [2]float32{10, 15}
+		n := tt.cs.NumColors()
+		h.CUPS.BitsPerPixel = tt.bpc * n
+		if tt.order != raster.ChunkyPixels {
+			h.CUPS.BitsPerPixel = tt.bpc
+		}
+		h.CUPS.BytesPerLine = (5*h.CUPS.BitsPerPixel + 7) / 8
+		lines := make([][]byte, h.CUPS.Height)
+		switch tt.order {
+		case raster.BandedPixels:
+			h.CUPS.BytesPerLine *= n
+		case raster.PlanarPixels:
+			lines = make([][]byte, h.CUPS.Height*n)
+		}
+		for i := range lines {
+			lines[i] = make([]byte, h.CUPS.BytesPerLine)
+			rng.Read(lines[i])
+		}
+		data := encodePage(h, lines, 3, binary.LittleEndian, t)
+		want, err := Image(decodePage(data, t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := NewStream(decodePage(data, t), 3)
+		if s.Bounds() != want.Bounds() {
+			t.Fatalf("%v %v: got bounds %v, want %v", tt.cs, tt.order, s.Bounds(), want.Bounds())
+		}
+		r := want.Bounds()
+		// Visit pixels in raster order, plus the previous line, which
+		// is still in the window.
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if got, want := s.At(x, y), want.At(x, y); got != want {
+					t.Errorf("%v %v: pixel (%d, %d) is %v, want %v", tt.cs, tt.order, x, y, got, want)
+				}
+				if y > r.Min.Y {
+					r1, g1, b1, a1 := want.At(x, y-1).RGBA()
+					c := s.RGBA64At(x, y-1)
+					if c != (color.RGBA64{uint16(r1), uint16(g1), uint16(b1), uint16(a1)}) {
+						t.Errorf("%v %v: RGBA64At(%d, %d) is %v, want %v", tt.cs, tt.order, x, y-1, c, want.At(x, y-1))
+					}
+				}
+			}
+		}
+		if err := s.Err(); err != nil {
+			t.Errorf("%v %v: %v", tt.cs, tt.order, err)
+		}
+	}
+}
+
+func TestStreamEvicted(t *testing.T) {
+	h := &raster.Header{HorizDPI: 72, VertDPI: 72}
+	h.CUPS.Width = 2
+	h.CUPS.Height = 6
+	h.CUPS.BitsPerColor = 8
+	h.CUPS.BitsPerPixel = 8
+	h.CUPS.BytesPerLine = 2
+	lines := make([][]byte, 6)
+	for i := range lines {
+		lines[i] = []byte{uint8(i), uint8(i + 100)}
+	}
+	s := NewStream(newPage(h, lines, t), 2)
+	if got := s.At(1, 4); got != (color.Gray{Y: 104}) {
+		t.Errorf("pixel (1, 4) is %v, want %v", got, color.Gray{Y: 104})
+	}
+	// Line 3 is still in the window, line 2 isn't.
+	if got := s.At(0, 3); got != (color.Gray{Y: 3}) {
+		t.Errorf("pixel (0, 3) is %v, want %v", got, color.Gray{Y: 3})
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("got error %v before accessing an evicted line", err)
+	}
+	if got := s.At(0, 2); got != (color.Gray{}) {
+		t.Errorf("evicted pixel (0, 2) is %v, want the zero color", got)
+	}
+	if got := s.RGBA64At(0, 2); got != (color.RGBA64{}) {
+		t.Errorf("evicted pixel (0, 2) is %v, want the zero color", got)
+	}
+	if err := s.Err(); err != ErrLineEvicted {
+		t.Errorf("got error %v, want ErrLineEvicted", err)
+	}
+	// Later lines can still be read.
+	if got := s.At(0, 5); got != (color.Gray{Y: 5}) {
+		t.Errorf("pixel (0, 5) is %v, want %v", got, color.Gray{Y: 5})
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	h := &raster.Header{HorizDPI: 72, VertDPI: 72}
+	h.CUPS.Width = 2
+	h.CUPS.Height = 4
+	h.CUPS.BitsPerColor = 8
+	h.CUPS.BitsPerPixel = 8
+	h.CUPS.BytesPerLine = 2
+	data := encodePage(h, [][]byte{{1, 2}, {3, 4}, {5, 6}, {7, 8}}, 3, binary.BigEndian, t)
+	s := NewStream(decodePage(data[:len(data)-3], t), 0)
+	if got := s.At(1, 2); got != (color.Gray{}) {
+		t.Errorf("pixel of a missing line is %v, want the zero color", got)
+	}
+	if err := s.Err(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got error %v, want io.ErrUnexpectedEOF", err)
+	}
+	if got := s.At(0, 1); got != (color.Gray{Y: 3}) {
+		t.Errorf("pixel (0, 1) is %v, want %v", got, color.Gray{Y: 3})
+	}
+}
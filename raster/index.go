@@ -0,0 +1,273 @@
+package raster
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// A PageIndex records the header and the position of every page in
+// a raster stream, allowing random access to its pages. It is safe
+// for concurrent use by multiple goroutines, as long as the
+// underlying io.ReaderAt is.
+type PageIndex struct {
+	r        io.ReaderAt
+	opts     DecoderOptions
+	version  int
+	bo       binary.ByteOrder
+	flavor   Flavor
+	urfPages int
+	pages    []indexEntry
+}
+
+type indexEntry struct {
+	header *Header
+	// offset and size describe the page's image data.
+	offset int64
+	size   int64
+}
+
+// Index reads the raster stream in r once and records the header and
+// position of each page. Image data is skipped without being decoded:
+// uncompressed pages are skipped entirely, and the lines of
+// compressed pages are parsed only to find their lengths.
+func Index(r io.ReaderAt) (*PageIndex, error) {
+	return IndexOptions(r, DecoderOptions{})
+}
+
+// IndexOptions is like Index, but decodes the stream with opts. The
+// options also apply to the pages returned by PageIndex.Page.
+func IndexOptions(r io.ReaderAt, opts DecoderOptions) (*PageIndex, error) {
+	ir := &indexReader{r: r}
+	d, err := NewDecoderOptions(ir, opts)
+	if err != nil {
+		return nil, err
+	}
+	idx := &PageIndex{
+		r:        r,
+		opts:     opts,
+		version:  d.version,
+		bo:       d.bo,
+		urfPages: d.urfPages,
+	}
+	for {
+		p, err := d.NextPage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var herr *HeaderError
+			if errors.As(err, &herr) && opts.Recover {
+				continue
+			}
+			return nil, err
+		}
+		off := d.Offset()
+		if err := p.skip(); err != nil {
+			if !opts.Recover {
+				return nil, err
+			}
+			// NextPage will resynchronize.
+			p.err = &DecodeError{Page: p.number, Line: p.linesRead - 1, Offset: d.Offset(), Err: err}
+		}
+		idx.pages = append(idx.pages, indexEntry{
+			header: p.Header,
+			offset: off,
+			size:   d.Offset() - off,
+		})
+	}
+	idx.flavor = d.flavor
+	return idx, nil
+}
+
+// Len returns the number of pages.
+func (idx *PageIndex) Len() int {
+	return len(idx.pages)
+}
+
+// Flavor returns the dialect of the stream.
+func (idx *PageIndex) Flavor() Flavor {
+	return idx.flavor
+}
+
+// Header returns the header of the i-th page, counting from zero. It
+// must not be modified.
+func (idx *PageIndex) Header(i int) *Header {
+	return idx.pages[i].header
+}
+
+// Offset returns the offset in the stream of the i-th page's image
+// data, and its size in bytes.
+func (idx *PageIndex) Offset(i int) (offset, size int64) {
+	return idx.pages[i].offset, idx.pages[i].size
+}
+
+// Page returns the i-th page, counting from zero, ready to be
+// decoded. Each call returns a new Page that reads from the
+// underlying io.ReaderAt independently of all other pages, so that
+// pages can be decoded concurrently. Its Header is a copy of the
+// indexed header. Page returns io.EOF if there is no i-th page.
+func (idx *PageIndex) Page(i int) (*Page, error) {
+	if i < 0 || i >= len(idx.pages) {
+		return nil, io.EOF
+	}
+	e := idx.pages[i]
+	d := &Decoder{
+		// Count offsets from the start of the stream.
+		r:        &countingReader{r: io.NewSectionReader(idx.r, e.offset, e.size), n: int(e.offset)},
+		bo:       idx.bo,
+		version:  idx.version,
+		flavor:   idx.flavor,
+		opts:     idx.opts,
+		urfPages: idx.urfPages,
+	}
+	h := *e.header
+	p, err := d.newPage(&h)
+	if err != nil {
+		return nil, err
+	}
+	p.number = i + 1
+	d.curPage = p
+	return p, nil
+}
+
+// skip skips the remaining lines of the page without decoding them.
+func (p *Page) skip() error {
+	switch p.dec.version {
+	case 1, 3:
+		n := int64(p.UnreadLines()) * int64(p.Header.CUPS.BytesPerLine)
+		p.linesRead = numLines(&p.Header.CUPS)
+		return p.dec.r.skip(n)
+	default:
+		for p.UnreadLines() > 0 {
+			p.linesRead++
+			if err := p.skipV2Line(); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// skipV2Line is like readV2Line, but only determines the length of
+// the compressed line.
+func (p *Page) skipV2Line() error {
+	if p.lineRep > 0 {
+		p.lineRep--
+		return nil
+	}
+	r := p.dec.r
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return err
+	}
+	p.lineRep = int(b[0])
+	bpc := int64(len(p.color))
+	for n := 0; n < p.Header.CUPS.BytesPerLine; {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return err
+		}
+		switch c := int(b[0]); {
+		case c <= 127:
+			if err := r.skip(bpc); err != nil {
+				return err
+			}
+			n += (c + 1) * int(bpc)
+		case c == 128 && p.dec.version == versionURF:
+			n = p.Header.CUPS.BytesPerLine
+		default:
+			c = 257 - c
+			if err := r.skip(int64(c) * bpc); err != nil {
+				return err
+			}
+			n += c * int(bpc)
+		}
+	}
+	return nil
+}
+
+// skip skips n bytes.
+func (r *countingReader) skip(n int64) error {
+	if buffered := int64(len(r.buf) - r.pos); n <= buffered {
+		r.pos += int(n)
+		r.n += int(n)
+		return nil
+	}
+	n -= int64(len(r.buf) - r.pos)
+	r.n += len(r.buf) - r.pos
+	r.buf, r.pos = r.buf[:0], 0
+	if r.err != nil {
+		err := r.err
+		r.err = nil
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if ir, ok := r.r.(*indexReader); ok {
+		if err := ir.skip(n); err != nil {
+			return err
+		}
+		r.n += int(n)
+		return nil
+	}
+	m, err := io.CopyN(ioutil.Discard, r.r, n)
+	r.n += int(m)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// indexReader reads sequentially from an io.ReaderAt, with
+// buffering, and can skip data without reading it.
+type indexReader struct {
+	r      io.ReaderAt
+	off    int64
+	buf    []byte
+	bufOff int64
+}
+
+func (r *indexReader) Read(b []byte) (int, error) {
+	if r.off < r.bufOff || r.off >= r.bufOff+int64(len(r.buf)) {
+		if r.buf == nil {
+			r.buf = make([]byte, 32*1024)
+		}
+		n, err := r.r.ReadAt(r.buf[:cap(r.buf)], r.off)
+		r.buf = r.buf[:n]
+		r.bufOff = r.off
+		if n == 0 {
+			if err == nil {
+				err = io.ErrNoProgress
+			}
+			return 0, err
+		}
+	}
+	n := copy(b, r.buf[r.off-r.bufOff:])
+	r.off += int64(n)
+	return n, nil
+}
+
+// skip skips n bytes. It returns io.ErrUnexpectedEOF if fewer than n
+// bytes are left.
+func (r *indexReader) skip(n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	end := r.off + n
+	if end > r.bufOff+int64(len(r.buf)) {
+		var b [1]byte
+		if n, err := r.r.ReadAt(b[:], end-1); n < 1 {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	r.off = end
+	return nil
+}
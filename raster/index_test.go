@@ -0,0 +1,120 @@
+package raster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func readFile(name string, t testing.TB) []byte {
+	f := open(name, t)
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestIndex(t *testing.T) {
+	two := readFile("two_pages", t)
+	_, pages := decodeAll(bytes.NewReader(two), t)
+	// Repeat the pages, so that there is something to index.
+	pages = append(pages, pages...)
+	pages = append(pages, pages...)
+
+	for _, version := range []int{1, 2, 3} {
+		buf := &bytes.Buffer{}
+		encodeAll(buf, version, binary.LittleEndian, pages, t)
+		_, want := decodeAll(bytes.NewReader(buf.Bytes()), t)
+
+		idx, err := Index(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+		if idx.Len() != len(want) {
+			t.Fatalf("v%d: indexed %d pages, want %d", version, idx.Len(), len(want))
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, idx.Len())
+		got := make([][][]byte, idx.Len())
+		for i := idx.Len() - 1; i >= 0; i-- {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				p, err := idx.Page(i)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				for p.UnreadLines() > 0 {
+					b := make([]byte, p.LineSize())
+					if err := p.ReadLine(b); err != nil {
+						errs[i] = err
+						return
+					}
+					got[i] = append(got[i], b)
+				}
+			}(i)
+		}
+		wg.Wait()
+		for i := range want {
+			if errs[i] != nil {
+				t.Errorf("v%d: page %d: %v", version, i, errs[i])
+				continue
+			}
+			if !reflect.DeepEqual(*idx.Header(i), *want[i].header) {
+				t.Errorf("v%d: page %d: header differs", version, i)
+			}
+			if !reflect.DeepEqual(got[i], want[i].lines) {
+				t.Errorf("v%d: page %d: image data differs", version, i)
+			}
+		}
+		off, size := idx.Offset(idx.Len() - 1)
+		if off+size != int64(buf.Len()) {
+			t.Errorf("v%d: last page ends at %d, want %d", version, off+size, buf.Len())
+		}
+	}
+
+	if _, err := Index(bytes.NewReader(readFile("raster_truncated", t))); err != io.ErrUnexpectedEOF {
+		t.Errorf("indexing truncated stream returned %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestIndexURF(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("UNIRAST\x00")
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	buf.Write(urfPageHeader(24, 1, 3, 5, 4, 3, 300))
+	buf.Write([]byte{1, 0, 255, 0, 0, 128})
+	buf.Write([]byte{0, 255, 1, 2, 3, 4, 5, 6, 1, 7, 8, 9})
+	buf.Write(urfPageHeader(32, 6, 2, 4, 2, 1, 600))
+	buf.Write([]byte{0, 0, 1, 2, 3, 4, 128})
+
+	idx, err := Index(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Len() != 2 || idx.Flavor() != FlavorURF {
+		t.Fatalf("indexed %d pages of flavor %d, want 2 URF pages", idx.Len(), idx.Flavor())
+	}
+	p, err := idx.Page(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, p.LineSize())
+	if err := p.ReadLine(b); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{1, 2, 3, 4, 0, 0, 0, 0}; !bytes.Equal(b, want) {
+		t.Errorf("got %v, want %v", b, want)
+	}
+	if _, err := idx.Page(2); err != io.EOF {
+		t.Errorf("opening page past the end returned %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,63 @@
+//go:build go1.23
+
+package raster
+
+import (
+	"io"
+	"iter"
+)
+
+// Pages returns an iterator over the remaining pages of the stream,
+// calling NextPage for each of them. A page must not be used to read
+// image data after the iteration has advanced past it, as NextPage
+// skips the unread lines of the previous page.
+//
+// The iteration ends at the end of the stream. If NextPage fails, the
+// error is yielded together with a nil page, and the iteration ends,
+// unless the decoder is in recovery mode and the error is caused by
+// the content of the stream, in which case decoding resumes at the
+// next page header. Errors of the underlying reader always end the
+// iteration. Breaking out of the loop leaves the decoder usable.
+func (d *Decoder) Pages() iter.Seq2[*Page, error] {
+	return func(yield func(*Page, error) bool) {
+		for {
+			p, err := d.NextPage()
+			if err == io.EOF {
+				return
+			}
+			if !yield(p, err) {
+				return
+			}
+			if err != nil && !(d.resync && isFormatError(err)) {
+				return
+			}
+		}
+	}
+}
+
+// Lines returns an iterator over the remaining lines of the page, as
+// returned by ReadLine, together with their index in the page. The
+// line is stored in buf, which is reused for every line; if buf is
+// smaller than a line, a buffer is allocated instead.
+//
+// The iteration ends after the last line or at the first error, which
+// is reported by Err afterwards. A page that is truncated thus ends
+// early, with Err returning an error that wraps io.ErrUnexpectedEOF.
+func (p *Page) Lines(buf []byte) iter.Seq2[int, []byte] {
+	return func(yield func(int, []byte) bool) {
+		bpl := p.Header.CUPS.BytesPerLine
+		if len(buf) < bpl {
+			buf = make([]byte, bpl)
+		}
+		buf = buf[:bpl]
+		for p.UnreadLines() > 0 {
+			y := p.linesRead
+			if err := p.ReadLine(buf); err != nil {
+				return
+			}
+			if !yield(y, buf) {
+				return
+			}
+		}
+	}
+}
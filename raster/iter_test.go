@@ -0,0 +1,141 @@
+//go:build go1.23
+
+package raster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestPages(t *testing.T) {
+	data := readFile("two_pages", t)
+	_, want := decodeAll(bytes.NewReader(data), t)
+
+	d, err := NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for p, err := range d.Pages() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := 0
+		// Only read part of the first page; Pages skips the rest.
+		for y, line := range p.Lines(nil) {
+			if y != lines {
+				t.Errorf("page %d: got line index %d, want %d", n, y, lines)
+			}
+			if !bytes.Equal(line, want[n].lines[y]) {
+				t.Errorf("page %d: line %d differs", n, y)
+			}
+			lines++
+			if n == 0 && lines == 3 {
+				break
+			}
+		}
+		if err := p.Err(); err != nil {
+			t.Errorf("page %d: %v", n, err)
+		}
+		n++
+	}
+	if n != len(want) {
+		t.Errorf("iterated over %d pages, want %d", n, len(want))
+	}
+	if _, err := d.NextPage(); err != io.EOF {
+		t.Errorf("NextPage after the iteration returned %v, want io.EOF", err)
+	}
+}
+
+func TestPagesTruncated(t *testing.T) {
+	f := open("raster_truncated", t)
+	defer f.Close()
+	d, err := NewDecoder(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	for p, err := range d.Pages() {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		lines := 0
+		for range p.Lines(make([]byte, 1)) {
+			lines++
+		}
+		if lines == p.Header.CUPS.Height {
+			t.Errorf("read all lines of a truncated page")
+		}
+		if err := p.Err(); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("got error %v, want io.ErrUnexpectedEOF", err)
+		}
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], io.ErrUnexpectedEOF) {
+		t.Errorf("got errors %v, want [io.ErrUnexpectedEOF]", errs)
+	}
+}
+
+// brokenReader returns data, and then err on every read.
+type brokenReader struct {
+	data []byte
+	err  error
+}
+
+func (r *brokenReader) Read(b []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(b, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestPagesReadError(t *testing.T) {
+	h := &Header{HorizDPI: 300, VertDPI: 300}
+	h.CUPS.Width = 8
+	h.CUPS.Height = 2
+	h.CUPS.BitsPerColor = 8
+	h.CUPS.BitsPerPixel = 8
+	h.CUPS.BytesPerLine = 8
+	buf := &bytes.Buffer{}
+	encodeAll(buf, 3, binary.BigEndian, []rawPage{{h, [][]byte{make([]byte, 8), make([]byte, 8)}}}, t)
+	// The connection breaks in the middle of the second line.
+	errReset := errors.New("connection reset")
+	r := &brokenReader{data: buf.Bytes()[:buf.Len()-4], err: errReset}
+
+	for _, recover := range []bool{false, true} {
+		r := *r
+		d, err := NewDecoderOptions(&r, DecoderOptions{Recover: recover})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var errs []error
+		pages := 0
+		for p, err := range d.Pages() {
+			if pages++; pages > 10 {
+				t.Fatalf("recover = %t: iteration doesn't end", recover)
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			lines := 0
+			for range p.Lines(nil) {
+				lines++
+			}
+			if lines != 1 {
+				t.Errorf("recover = %t: read %d lines, want 1", recover, lines)
+			}
+			if err := p.Err(); !errors.Is(err, errReset) {
+				t.Errorf("recover = %t: got page error %v, want %v", recover, err, errReset)
+			}
+		}
+		if len(errs) != 1 || !errors.Is(errs[0], errReset) {
+			t.Errorf("recover = %t: got errors %v, want [%v]", recover, errs, errReset)
+		}
+	}
+}
@@ -0,0 +1,464 @@
+package raster
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image/color"
+	"io"
+)
+
+// The typed line accessors in this file decode lines directly into
+// slices of concrete color types. Unlike ReadLineColors, they don't
+// allocate per pixel, and they don't allocate at all for the gray,
+// RGB, RGBA and CMYK color spaces. Other color spaces are converted
+// via the colors returned by ParseColors.
+//
+// Each accessor reads the next line and stores one color per pixel
+// in dst, which must hold at least CUPS.Width colors. The conversions
+// match those of the corresponding color models in image/color,
+// applied to the colors returned by ParseColors. The exceptions are
+// ReadLineCMYK and ReadLineCMYK64, which store the colors of CMYK
+// color spaces without converting them via RGB.
+//
+// For pages with PlanarPixels, the first call reads the remainder of
+// the page into memory, like ReadLineColors does.
+
+// maxColors is the largest number of colors per pixel, used by
+// ColorSpaceDeviceF and ColorSpaceICCF.
+const maxColors = 15
+
+// lineSamples locates the samples of pixels in image data.
+type lineSamples struct {
+	// sep holds the data. For chunky pixels, only sep[0] is used.
+	sep     [maxColors][]byte
+	chunky  bool
+	n       int
+	bpc     int
+	bpp     int
+	pad     int
+	max     uint32
+	bo      binary.ByteOrder
+	samples int
+}
+
+// get stores the raw samples of the x-th pixel in s.
+func (l *lineSamples) get(x int, s []uint16) {
+	if l.chunky {
+		off := x*l.bpp + l.pad
+		for i := 0; i < l.n; i++ {
+			s[i] = sample(l.sep[0], off+i*l.bpc, l.bpc, l.bo)
+		}
+		return
+	}
+	off := x * l.bpc
+	for i := 0; i < l.n; i++ {
+		s[i] = sample(l.sep[i], off, l.bpc, l.bo)
+	}
+}
+
+// scale16 scales a raw sample to 16 bits.
+func (l *lineSamples) scale16(v uint16) uint16 {
+	if l.bpc == 16 {
+		return v
+	}
+	return uint16(uint32(v) * 0xffff / l.max)
+}
+
+// scale8 scales a raw sample to 8 bits.
+func (l *lineSamples) scale8(v uint16) uint8 {
+	if l.bpc == 16 {
+		return uint8(v >> 8)
+	}
+	return uint8(uint32(v) * 255 / l.max)
+}
+
+// checkFormat checks that the page's colors can be parsed, and
+// returns the number of colors per pixel.
+func (p *Page) checkFormat() (int, error) {
+	h := &p.Header.CUPS
+	n := numColors(h)
+	if n == 0 {
+		return 0, ErrUnsupported
+	}
+	switch h.BitsPerColor {
+	case 1, 2, 4, 8, 16:
+	default:
+		return 0, ErrUnsupported
+	}
+	return n, nil
+}
+
+// chunkySamples returns the samples of the chunky pixels in b.
+func (p *Page) chunkySamples(b []byte, n int) (lineSamples, error) {
+	// Pixels may be padded, for example to 4 bits for 1-bit RGB.
+	// The padding precedes the colors.
+	bpc := p.Header.CUPS.BitsPerColor
+	bpp := p.Header.CUPS.BitsPerPixel
+	if bpp < n*bpc || bpp%bpc != 0 {
+		return lineSamples{}, ErrInvalidFormat
+	}
+	if bpp >= 8 && len(b)*8%bpp != 0 {
+		return lineSamples{}, ErrInvalidFormat
+	}
+	l := lineSamples{
+		chunky:  true,
+		n:       n,
+		bpc:     bpc,
+		bpp:     bpp,
+		pad:     bpp - n*bpc,
+		max:     uint32(1)<<uint(bpc) - 1,
+		bo:      p.byteOrder(),
+		samples: len(b) * 8 / bpp,
+	}
+	l.sep[0] = b
+	return l, nil
+}
+
+// separatedSamples returns the samples of pixels whose colors are
+// stored in separate slices of equal length, one per color.
+func (p *Page) separatedSamples(sep [][]byte) (lineSamples, error) {
+	bpc := p.Header.CUPS.BitsPerColor
+	size := len(sep[0]) * 8
+	if size%bpc != 0 || len(sep) > maxColors {
+		return lineSamples{}, ErrInvalidFormat
+	}
+	l := lineSamples{
+		n:       len(sep),
+		bpc:     bpc,
+		max:     uint32(1)<<uint(bpc) - 1,
+		bo:      p.byteOrder(),
+		samples: size / bpc,
+	}
+	copy(l.sep[:], sep)
+	return l, nil
+}
+
+// nextSamples reads the next line and returns its samples.
+func (p *Page) nextSamples(ctx context.Context) (lineSamples, error) {
+	h := &p.Header.CUPS
+	n, err := p.checkFormat()
+	if err != nil {
+		return lineSamples{}, err
+	}
+	switch h.ColorOrder {
+	case ChunkyPixels, BandedPixels:
+		if err := ctx.Err(); err != nil {
+			return lineSamples{}, err
+		}
+		if err := p.ReadLine(p.lineBuffer()); err != nil {
+			return lineSamples{}, err
+		}
+		if h.ColorOrder == ChunkyPixels {
+			return p.chunkySamples(p.scratch, n)
+		}
+		if h.BytesPerLine%n != 0 {
+			return lineSamples{}, ErrInvalidFormat
+		}
+		// Each line consists of one band per color.
+		var sep [maxColors][]byte
+		band := h.BytesPerLine / n
+		for i := 0; i < n; i++ {
+			sep[i] = p.scratch[i*band : (i+1)*band]
+		}
+		return p.separatedSamples(sep[:n])
+	case PlanarPixels:
+		if p.planes == nil {
+			if p.linesRead != 0 {
+				return lineSamples{}, ErrUnsupported
+			}
+			if p.UnreadLines() == 0 {
+				return lineSamples{}, io.EOF
+			}
+			planes := make([]byte, p.Size())
+			if err := p.ReadAllContext(ctx, planes); err != nil {
+				return lineSamples{}, err
+			}
+			p.planes = planes
+		}
+		if p.planarLine >= h.Height {
+			return lineSamples{}, io.EOF
+		}
+		var sep [maxColors][]byte
+		plane := len(p.planes) / n
+		for i := 0; i < n; i++ {
+			start := i*plane + p.planarLine*h.BytesPerLine
+			sep[i] = p.planes[start : start+h.BytesPerLine]
+		}
+		p.planarLine++
+		return p.separatedSamples(sep[:n])
+	default:
+		return lineSamples{}, ErrInvalidFormat
+	}
+}
+
+// readLine reads the next line for a typed accessor whose
+// destination has length dst.
+func (p *Page) readLine(dst int) (lineSamples, error) {
+	if dst < p.Header.CUPS.Width {
+		return lineSamples{}, ErrBufferTooSmall
+	}
+	l, err := p.nextSamples(context.Background())
+	if err != nil {
+		return l, err
+	}
+	if l.samples < p.Header.CUPS.Width {
+		return l, ErrInvalidFormat
+	}
+	return l, nil
+}
+
+// cmyk returns the 16-bit CMYK components of a pixel with raw
+// samples s, and whether the color space is a CMYK color space.
+func (p *Page) cmyk(l *lineSamples, s []uint16) (c, m, y, k uint16, ok bool) {
+	cs := p.Header.CUPS.ColorSpace
+	if cs == ColorSpaceKCMYcm && l.bpc == 1 {
+		ink := func(full, light uint16) uint16 {
+			switch {
+			case full == 1:
+				return 0xffff
+			case light == 1:
+				return 0x8000
+			default:
+				return 0
+			}
+		}
+		return ink(s[1], s[4]), ink(s[2], s[5]), ink(s[3], 0), ink(s[0], 0), true
+	}
+	var ic, im, iy, ik int
+	switch cs {
+	case ColorSpaceCMY:
+		ic, im, iy, ik = 0, 1, 2, -1
+	case ColorSpaceYMC:
+		ic, im, iy, ik = 2, 1, 0, -1
+	case ColorSpaceCMYK:
+		ic, im, iy, ik = 0, 1, 2, 3
+	case ColorSpaceYMCK, ColorSpaceGMCK, ColorSpaceGMCS:
+		ic, im, iy, ik = 2, 1, 0, 3
+	case ColorSpaceKCMY, ColorSpaceKCMYcm:
+		ic, im, iy, ik = 1, 2, 3, 0
+	default:
+		return 0, 0, 0, 0, false
+	}
+	c, m, y = l.scale16(s[ic]), l.scale16(s[im]), l.scale16(s[iy])
+	if ik >= 0 {
+		k = l.scale16(s[ik])
+	}
+	return c, m, y, k, true
+}
+
+// rgba returns the alpha-premultiplied 16-bit color of a pixel with
+// raw samples s, like color.Color.RGBA. s8 is used as scratch space.
+func (p *Page) rgba(l *lineSamples, s []uint16, s8 []uint8) (r, g, b, a uint32) {
+	switch p.Header.CUPS.ColorSpace {
+	case ColorSpaceGray, ColorSpacesGray:
+		y := uint32(l.scale16(s[0]))
+		return y, y, y, 0xffff
+	case ColorSpaceBlack, ColorSpaceWHITE, ColorSpaceGOLD, ColorSpaceSILVER:
+		y := 0xffff - uint32(l.scale16(s[0]))
+		return y, y, y, 0xffff
+	case ColorSpaceRGB, ColorSpacesRGB, ColorSpaceAdobeRGB:
+		return uint32(l.scale16(s[0])), uint32(l.scale16(s[1])), uint32(l.scale16(s[2])), 0xffff
+	case ColorSpaceRGBA:
+		a = uint32(l.scale16(s[3]))
+		r = uint32(l.scale16(s[0])) * a / 0xffff
+		g = uint32(l.scale16(s[1])) * a / 0xffff
+		b = uint32(l.scale16(s[2])) * a / 0xffff
+		return r, g, b, a
+	}
+	if c, m, y, k, ok := p.cmyk(l, s); ok {
+		return CMYK64{c, m, y, k}.RGBA()
+	}
+	// Other color spaces need floating point math or have no
+	// obvious representation; use their color types.
+	return p.pixel(s[:l.n], s8).RGBA()
+}
+
+// ReadLineGray reads the next line as 8-bit gray levels.
+func (p *Page) ReadLineGray(dst []uint8) error {
+	l, err := p.readLine(len(dst))
+	if err != nil {
+		return err
+	}
+	var s [maxColors]uint16
+	var s8 [maxColors]uint8
+	for x := range dst[:p.Header.CUPS.Width] {
+		l.get(x, s[:])
+		r, g, b, _ := p.rgba(&l, s[:], s8[:])
+		dst[x] = uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 24)
+	}
+	return nil
+}
+
+// ReadLineGray16 reads the next line as 16-bit gray levels.
+func (p *Page) ReadLineGray16(dst []uint16) error {
+	l, err := p.readLine(len(dst))
+	if err != nil {
+		return err
+	}
+	var s [maxColors]uint16
+	var s8 [maxColors]uint8
+	for x := range dst[:p.Header.CUPS.Width] {
+		l.get(x, s[:])
+		r, g, b, _ := p.rgba(&l, s[:], s8[:])
+		dst[x] = uint16((19595*r + 38470*g + 7471*b + 1<<15) >> 16)
+	}
+	return nil
+}
+
+// ReadLineRGBA reads the next line as alpha-premultiplied 8-bit
+// colors.
+func (p *Page) ReadLineRGBA(dst []color.RGBA) error {
+	l, err := p.readLine(len(dst))
+	if err != nil {
+		return err
+	}
+	var s [maxColors]uint16
+	var s8 [maxColors]uint8
+	for x := range dst[:p.Header.CUPS.Width] {
+		l.get(x, s[:])
+		r, g, b, a := p.rgba(&l, s[:], s8[:])
+		dst[x] = color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+	}
+	return nil
+}
+
+// ReadLineRGBA64 reads the next line as alpha-premultiplied 16-bit
+// colors.
+func (p *Page) ReadLineRGBA64(dst []color.RGBA64) error {
+	l, err := p.readLine(len(dst))
+	if err != nil {
+		return err
+	}
+	var s [maxColors]uint16
+	var s8 [maxColors]uint8
+	for x := range dst[:p.Header.CUPS.Width] {
+		l.get(x, s[:])
+		r, g, b, a := p.rgba(&l, s[:], s8[:])
+		dst[x] = color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+	}
+	return nil
+}
+
+// ReadLineNRGBA reads the next line as non-alpha-premultiplied 8-bit
+// colors. Pages with ColorSpaceRGBA and up to 8 bits per color are
+// read without premultiplying their colors.
+func (p *Page) ReadLineNRGBA(dst []color.NRGBA) error {
+	l, err := p.readLine(len(dst))
+	if err != nil {
+		return err
+	}
+	var s [maxColors]uint16
+	var s8 [maxColors]uint8
+	direct := p.Header.CUPS.ColorSpace == ColorSpaceRGBA && l.bpc <= 8
+	for x := range dst[:p.Header.CUPS.Width] {
+		l.get(x, s[:])
+		if direct {
+			dst[x] = color.NRGBA{l.scale8(s[0]), l.scale8(s[1]), l.scale8(s[2]), l.scale8(s[3])}
+			continue
+		}
+		r, g, b, a := p.rgba(&l, s[:], s8[:])
+		if a != 0 && a != 0xffff {
+			r, g, b = r*0xffff/a, g*0xffff/a, b*0xffff/a
+		}
+		dst[x] = color.NRGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+	}
+	return nil
+}
+
+// ReadLineNRGBA64 reads the next line as non-alpha-premultiplied
+// 16-bit colors. Pages with ColorSpaceRGBA and 16 bits per color are
+// read without premultiplying their colors.
+func (p *Page) ReadLineNRGBA64(dst []color.NRGBA64) error {
+	l, err := p.readLine(len(dst))
+	if err != nil {
+		return err
+	}
+	var s [maxColors]uint16
+	var s8 [maxColors]uint8
+	direct := p.Header.CUPS.ColorSpace == ColorSpaceRGBA && l.bpc == 16
+	for x := range dst[:p.Header.CUPS.Width] {
+		l.get(x, s[:])
+		if direct {
+			dst[x] = color.NRGBA64{l.scale16(s[0]), l.scale16(s[1]), l.scale16(s[2]), l.scale16(s[3])}
+			continue
+		}
+		r, g, b, a := p.rgba(&l, s[:], s8[:])
+		if a != 0 && a != 0xffff {
+			r, g, b = r*0xffff/a, g*0xffff/a, b*0xffff/a
+		}
+		dst[x] = color.NRGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+	}
+	return nil
+}
+
+// ReadLineCMYK reads the next line as 8-bit CMYK colors. Colors of
+// CMYK color spaces are reordered and scaled to 8 bits, like
+// ParseColors does; the colors of other color spaces are converted
+// like color.CMYKModel does.
+func (p *Page) ReadLineCMYK(dst []color.CMYK) error {
+	l, err := p.readLine(len(dst))
+	if err != nil {
+		return err
+	}
+	var s [maxColors]uint16
+	var s8 [maxColors]uint8
+	for x := range dst[:p.Header.CUPS.Width] {
+		l.get(x, s[:])
+		if c, m, y, k, ok := p.cmyk(&l, s[:]); ok {
+			dst[x] = color.CMYK{uint8(c >> 8), uint8(m >> 8), uint8(y >> 8), uint8(k >> 8)}
+			continue
+		}
+		r, g, b, _ := p.rgba(&l, s[:], s8[:])
+		c, m, y, k := color.RGBToCMYK(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+		dst[x] = color.CMYK{c, m, y, k}
+	}
+	return nil
+}
+
+// ReadLineCMYK64 reads the next line as 16-bit CMYK colors. Colors
+// of CMYK color spaces are reordered and scaled to 16 bits; the
+// colors of other color spaces are converted like CMYK64Model does.
+func (p *Page) ReadLineCMYK64(dst []CMYK64) error {
+	l, err := p.readLine(len(dst))
+	if err != nil {
+		return err
+	}
+	var s [maxColors]uint16
+	var s8 [maxColors]uint8
+	for x := range dst[:p.Header.CUPS.Width] {
+		l.get(x, s[:])
+		if c, m, y, k, ok := p.cmyk(&l, s[:]); ok {
+			dst[x] = CMYK64{c, m, y, k}
+			continue
+		}
+		r, g, b, a := p.rgba(&l, s[:], s8[:])
+		dst[x] = cmyk64Model(color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}).(CMYK64)
+	}
+	return nil
+}
+
+// WithData returns a copy of p that reads its image data from b
+// instead of from the stream, starting at the first line. b holds the
+// page's entire uncompressed image data, as returned by ReadAll on an
+// unread page. This allows using the typed line accessors on data
+// that has been read already, for example by Decoder.Pipeline. The
+// header is shared with p. The offsets of DecodeErrors returned by the
+// copy are those of p's image data in the stream plus the position in
+// b, which only match positions in the stream if it is uncompressed.
+func (p *Page) WithData(b []byte) *Page {
+	d := &Decoder{
+		r:       &countingReader{r: bytes.NewReader(b), n: int(p.offset)},
+		bo:      p.byteOrder(),
+		version: 3,
+	}
+	np, err := d.newPage(p.Header)
+	if err != nil {
+		// bytesPerColor only fails for headers that NextPage
+		// doesn't return.
+		np = &Page{Header: p.Header, dec: d}
+	}
+	np.number = p.number
+	d.curPage = np
+	return np
+}
@@ -0,0 +1,375 @@
+package raster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// lineAccessor reads a line with one of the typed line accessors and
+// returns its colors, together with the color model that converts
+// the colors returned by ParseColors to the same values.
+type lineAccessor struct {
+	name  string
+	model color.Model
+	read  func(p *Page, w int) ([]color.Color, error)
+}
+
+var lineAccessors = []lineAccessor{
+	{"Gray", color.GrayModel, func(p *Page, w int) ([]color.Color, error) {
+		dst := make([]uint8, w)
+		err := p.ReadLineGray(dst)
+		out := make([]color.Color, w)
+		for i, v := range dst {
+			out[i] = color.Gray{Y: v}
+		}
+		return out, err
+	}},
+	{"Gray16", color.Gray16Model, func(p *Page, w int) ([]color.Color, error) {
+		dst := make([]uint16, w)
+		err := p.ReadLineGray16(dst)
+		out := make([]color.Color, w)
+		for i, v := range dst {
+			out[i] = color.Gray16{Y: v}
+		}
+		return out, err
+	}},
+	{"RGBA", color.RGBAModel, func(p *Page, w int) ([]color.Color, error) {
+		dst := make([]color.RGBA, w)
+		err := p.ReadLineRGBA(dst)
+		out := make([]color.Color, w)
+		for i, v := range dst {
+			out[i] = v
+		}
+		return out, err
+	}},
+	{"RGBA64", color.RGBA64Model, func(p *Page, w int) ([]color.Color, error) {
+		dst := make([]color.RGBA64, w)
+		err := p.ReadLineRGBA64(dst)
+		out := make([]color.Color, w)
+		for i, v := range dst {
+			out[i] = v
+		}
+		return out, err
+	}},
+	{"NRGBA", color.NRGBAModel, func(p *Page, w int) ([]color.Color, error) {
+		dst := make([]color.NRGBA, w)
+		err := p.ReadLineNRGBA(dst)
+		out := make([]color.Color, w)
+		for i, v := range dst {
+			out[i] = v
+		}
+		return out, err
+	}},
+	{"NRGBA64", color.NRGBA64Model, func(p *Page, w int) ([]color.Color, error) {
+		dst := make([]color.NRGBA64, w)
+		err := p.ReadLineNRGBA64(dst)
+		out := make([]color.Color, w)
+		for i, v := range dst {
+			out[i] = v
+		}
+		return out, err
+	}},
+	{"CMYK", color.ModelFunc(func(c color.Color) color.Color {
+		if c, ok := nativeCMYK(c); ok {
+			return color.CMYK{C: uint8(c.C >> 8), M: uint8(c.M >> 8), Y: uint8(c.Y >> 8), K: uint8(c.K >> 8)}
+		}
+		return color.CMYKModel.Convert(c)
+	}), func(p *Page, w int) ([]color.Color, error) {
+		dst := make([]color.CMYK, w)
+		err := p.ReadLineCMYK(dst)
+		out := make([]color.Color, w)
+		for i, v := range dst {
+			out[i] = v
+		}
+		return out, err
+	}},
+	{"CMYK64", color.ModelFunc(func(c color.Color) color.Color {
+		if c, ok := nativeCMYK(c); ok {
+			return c
+		}
+		return CMYK64Model.Convert(c)
+	}), func(p *Page, w int) ([]color.Color, error) {
+		dst := make([]CMYK64, w)
+		err := p.ReadLineCMYK64(dst)
+		out := make([]color.Color, w)
+		for i, v := range dst {
+			out[i] = v
+		}
+		return out, err
+	}},
+}
+
+// nativeCMYK returns the 16-bit components of colors of CMYK color
+// spaces, as returned by ParseColors.
+func nativeCMYK(c color.Color) (CMYK64, bool) {
+	ink := func(full, light bool) uint16 {
+		switch {
+		case full:
+			return 0xffff
+		case light:
+			return 0x8000
+		default:
+			return 0
+		}
+	}
+	switch c := c.(type) {
+	case color.CMYK:
+		return CMYK64{uint16(c.C) * 0x101, uint16(c.M) * 0x101, uint16(c.Y) * 0x101, uint16(c.K) * 0x101}, true
+	case CMYK64:
+		return c, true
+	case KCMYcm:
+		return CMYK64{ink(c.C, c.LC), ink(c.M, c.LM), ink(c.Y, false), ink(c.K, false)}, true
+	default:
+		return CMYK64{}, false
+	}
+}
+
+// randomPage returns a single page stream of the given format,
+// filled with random image data.
+func randomPage(cs ColorSpace, order ColorOrder, bpc int, t *testing.T) []byte {
+	h := &Header{}
+	h.CUPS.Width = 37
+	h.CUPS.Height = 5
+	h.CUPS.ColorSpace = cs
+	h.CUPS.ColorOrder = order
+	h.CUPS.BitsPerColor = bpc
+	n := numColors(&h.CUPS)
+	switch order {
+	case ChunkyPixels:
+		h.CUPS.BitsPerPixel = bpc * n
+		h.CUPS.BytesPerLine = (h.CUPS.Width*h.CUPS.BitsPerPixel + 7) / 8
+	case BandedPixels:
+		h.CUPS.BitsPerPixel = bpc
+		h.CUPS.BytesPerLine = (h.CUPS.Width*bpc + 7) / 8 * n
+	case PlanarPixels:
+		h.CUPS.BitsPerPixel = bpc
+		h.CUPS.BytesPerLine = (h.CUPS.Width*bpc + 7) / 8
+	}
+	rng := rand.New(rand.NewSource(int64(cs)*100 + int64(order)*20 + int64(bpc)))
+	rp := rawPage{header: h}
+	for i := 0; i < numLines(&h.CUPS); i++ {
+		b := make([]byte, h.CUPS.BytesPerLine)
+		rng.Read(b)
+		rp.lines = append(rp.lines, b)
+	}
+	buf := &bytes.Buffer{}
+	encodeAll(buf, 3, binary.LittleEndian, []rawPage{rp}, t)
+	return buf.Bytes()
+}
+
+func firstPage(b []byte, t testing.TB) *Page {
+	d, err := NewDecoder(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := d.NextPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestReadLineTyped(t *testing.T) {
+	streams := map[string][]byte{}
+	for _, name := range []string{
+		"gradient_chunked_k_1_1",
+		"gradient_chunked_k_8_8",
+		"gradient_chunked_cmyk_1_4",
+		"gradient_chunked_cmyk_8_32",
+	} {
+		streams[name] = readFile(name, t)
+	}
+	formats := []struct {
+		name  string
+		cs    ColorSpace
+		order ColorOrder
+		bpc   int
+	}{
+		{"gray_2", ColorSpaceGray, ChunkyPixels, 2},
+		{"gray_16", ColorSpaceGray, ChunkyPixels, 16},
+		{"white_4", ColorSpaceWHITE, ChunkyPixels, 4},
+		{"rgb_8", ColorSpaceRGB, ChunkyPixels, 8},
+		{"rgb_16_banded", ColorSpacesRGB, BandedPixels, 16},
+		{"rgba_8", ColorSpaceRGBA, ChunkyPixels, 8},
+		{"rgba_16", ColorSpaceRGBA, ChunkyPixels, 16},
+		{"ymck_8_planar", ColorSpaceYMCK, PlanarPixels, 8},
+		{"cmy_16", ColorSpaceCMY, ChunkyPixels, 16},
+		{"kcmycm_1", ColorSpaceKCMYcm, ChunkyPixels, 1},
+		{"kcmycm_8", ColorSpaceKCMYcm, BandedPixels, 8},
+		{"rgbw_8", ColorSpaceRGBW, ChunkyPixels, 8},
+		{"lab_8", ColorSpaceCIELab, ChunkyPixels, 8},
+		{"device3_16", ColorSpaceDevice3, ChunkyPixels, 16},
+	}
+	for _, f := range formats {
+		streams[f.name] = randomPage(f.cs, f.order, f.bpc, t)
+	}
+
+	for name, b := range streams {
+		for _, acc := range lineAccessors {
+			want := firstPage(b, t)
+			got := firstPage(b, t)
+			w := want.Header.CUPS.Width
+			buf := make([]byte, want.LineSize())
+			for y := 0; y < want.Header.CUPS.Height; y++ {
+				colors, err := want.ReadLineColors(buf)
+				if err != nil {
+					t.Fatalf("%s: ReadLineColors: %v", name, err)
+				}
+				line, err := acc.read(got, w)
+				if err != nil {
+					t.Fatalf("%s: ReadLine%s: %v", name, acc.name, err)
+				}
+				for x := range line {
+					if exp := acc.model.Convert(colors[x]); line[x] != exp {
+						t.Fatalf("%s: ReadLine%s: pixel (%d, %d) is %v, want %v", name, acc.name, x, y, line[x], exp)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReadLineTypedErrors(t *testing.T) {
+	p := firstPage(readFile("gradient_chunked_k_8_8", t), t)
+	w := p.Header.CUPS.Width
+	if err := p.ReadLineGray(make([]uint8, w-1)); err != ErrBufferTooSmall {
+		t.Errorf("got %v, want ErrBufferTooSmall", err)
+	}
+	if p.UnreadLines() != p.Header.CUPS.Height {
+		t.Errorf("short buffer consumed a line")
+	}
+	dst := make([]uint8, w)
+	for p.UnreadLines() > 0 {
+		if err := p.ReadLineGray(dst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.ReadLineGray(dst); err == nil {
+		t.Errorf("reading past the last line succeeded")
+	}
+}
+
+func TestWithData(t *testing.T) {
+	b := randomPage(ColorSpaceCMYK, PlanarPixels, 8, t)
+	p := firstPage(b, t)
+	data := make([]byte, p.Size())
+	if err := p.ReadAll(data); err != nil {
+		t.Fatal(err)
+	}
+	want := firstPage(b, t)
+	got := p.WithData(data)
+	w := p.Header.CUPS.Width
+	c1 := make([]color.CMYK, w)
+	c2 := make([]color.CMYK, w)
+	for y := 0; y < p.Header.CUPS.Height; y++ {
+		if err := want.ReadLineCMYK(c1); err != nil {
+			t.Fatal(err)
+		}
+		if err := got.ReadLineCMYK(c2); err != nil {
+			t.Fatal(err)
+		}
+		for x := range c1 {
+			if c1[x] != c2[x] {
+				t.Fatalf("pixel (%d, %d) is %v, want %v", x, y, c2[x], c1[x])
+			}
+		}
+	}
+
+	// Offsets are relative to the stream, not to the data.
+	short := data[:len(data)-1]
+	sp := p.WithData(short)
+	err := sp.ReadAll(make([]byte, sp.Size()))
+	var derr *DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("got error %v, want a *DecodeError", err)
+	}
+	if want := int64(4 + headerSizeV2 + len(short)); derr.Offset != want {
+		t.Errorf("got offset %d, want %d", derr.Offset, want)
+	}
+}
+
+// benchmarkLines reads all lines of the first page of the named
+// fixture with read.
+func benchmarkLines(b *testing.B, name string, read func(p *Page) error) {
+	data := readFile(name, b)
+	p := firstPage(data, b)
+	b.SetBytes(int64(p.Size()))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := firstPage(data, b)
+		for p.UnreadLines() > 0 {
+			if err := read(p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+var benchmarkFixtures = []string{
+	"gradient_chunked_k_1_1",
+	"gradient_chunked_k_8_8",
+	"gradient_chunked_cmyk_1_4",
+	"gradient_chunked_cmyk_8_32",
+}
+
+func BenchmarkReadLineColors(b *testing.B) {
+	for _, name := range benchmarkFixtures {
+		b.Run(name, func(b *testing.B) {
+			var buf []byte
+			benchmarkLines(b, name, func(p *Page) error {
+				if buf == nil {
+					buf = make([]byte, p.LineSize())
+				}
+				_, err := p.ReadLineColors(buf)
+				return err
+			})
+		})
+	}
+}
+
+func BenchmarkReadLineGray(b *testing.B) {
+	for _, name := range benchmarkFixtures {
+		b.Run(name, func(b *testing.B) {
+			var dst []uint8
+			benchmarkLines(b, name, func(p *Page) error {
+				if dst == nil {
+					dst = make([]uint8, p.Header.CUPS.Width)
+				}
+				return p.ReadLineGray(dst)
+			})
+		})
+	}
+}
+
+func BenchmarkReadLineRGBA(b *testing.B) {
+	for _, name := range benchmarkFixtures {
+		b.Run(name, func(b *testing.B) {
+			var dst []color.RGBA
+			benchmarkLines(b, name, func(p *Page) error {
+				if dst == nil {
+					dst = make([]color.RGBA, p.Header.CUPS.Width)
+				}
+				return p.ReadLineRGBA(dst)
+			})
+		})
+	}
+}
+
+func BenchmarkReadLineCMYK(b *testing.B) {
+	for _, name := range benchmarkFixtures {
+		b.Run(name, func(b *testing.B) {
+			var dst []color.CMYK
+			benchmarkLines(b, name, func(p *Page) error {
+				if dst == nil {
+					dst = make([]color.CMYK, p.Header.CUPS.Width)
+				}
+				return p.ReadLineCMYK(dst)
+			})
+		})
+	}
+}
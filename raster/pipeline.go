@@ -0,0 +1,155 @@
+package raster
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// PipelineOptions configure Decoder.Pipeline.
+type PipelineOptions struct {
+	// Workers is the number of goroutines that process pages. It
+	// defaults to runtime.GOMAXPROCS(0).
+	Workers int
+	// MaxPending is the maximum number of pages that have been read
+	// but whose results haven't been output yet. It bounds the
+	// memory used by the pipeline to MaxPending pages of image data
+	// and their results. It defaults to twice the number of workers.
+	MaxPending int
+}
+
+type pipelineJob struct {
+	index  int
+	page   *Page
+	data   []byte
+	result interface{}
+	err    error
+	done   chan struct{}
+}
+
+// Pipeline decodes the remaining pages of the stream concurrently.
+// Pages are read sequentially, and the image data of each page is
+// handed to process, which runs on a pool of worker goroutines. The
+// results are passed to output in the order of the pages, on the
+// goroutine that called Pipeline. index counts the pages passed to
+// process, starting at zero.
+//
+// The pages passed to process and output can't be used to read image
+// data; data holds the page's entire image data instead, to be used
+// with Page.ParseColors, for example.
+//
+// Pipeline stops at the first error returned by process or output,
+// or when ctx is canceled, and returns that error. When the decoder
+// returns an error, the pages read before are processed and output
+// before Pipeline returns the error. Pipeline returns nil when all
+// pages have been output.
+func (d *Decoder) Pipeline(
+	ctx context.Context,
+	opts PipelineOptions,
+	process func(index int, p *Page, data []byte) (interface{}, error),
+	output func(index int, p *Page, result interface{}) error,
+) error {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	pending := opts.MaxPending
+	if pending <= 0 {
+		pending = 2 * workers
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		jobs    = make(chan *pipelineJob)
+		ordered = make(chan *pipelineJob, pending)
+		// tokens limits the number of pages in flight.
+		tokens  = make(chan struct{}, pending)
+		readErr error
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if ctx.Err() != nil {
+					job.err = ctx.Err()
+				} else {
+					job.result, job.err = process(job.index, job.page, job.data)
+				}
+				close(job.done)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(ordered)
+		defer close(jobs)
+		for i := 0; ; i++ {
+			select {
+			case tokens <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			p, err := d.NextPageContext(ctx)
+			if err == io.EOF {
+				return
+			}
+			if err == nil {
+				data := make([]byte, p.Size())
+				if len(data) > 0 {
+					err = p.ReadAllContext(ctx, data)
+				}
+				if err == nil {
+					job := &pipelineJob{index: i, page: p, data: data, done: make(chan struct{})}
+					// ordered has room for every page that holds a
+					// token, so this never blocks.
+					ordered <- job
+					select {
+					case jobs <- job:
+						continue
+					case <-ctx.Done():
+						job.err = ctx.Err()
+						close(job.done)
+						return
+					}
+				}
+			}
+			// Pages that have been read already are still
+			// processed and output.
+			readErr = err
+			return
+		}
+	}()
+
+	var err error
+	for job := range ordered {
+		<-job.done
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = job.err
+			if err == nil {
+				err = output(job.index, job.page, job.result)
+			}
+			if err != nil {
+				cancel()
+			}
+		}
+		// Release the memory of the page.
+		job.data, job.result = nil, nil
+		<-tokens
+	}
+	wg.Wait()
+	if err == nil {
+		err = readErr
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package raster
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image/color"
+	"io"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func pipelineStream(n int, t *testing.T) []byte {
+	f := open("two_pages", t)
+	_, pages := decodeAll(f, t)
+	f.Close()
+	var all []rawPage
+	for i := 0; i < n; i++ {
+		p := pages[i%len(pages)]
+		h := *p.header
+		h.NumCopies = i
+		all = append(all, rawPage{&h, p.lines})
+	}
+	buf := &bytes.Buffer{}
+	encodeAll(buf, 2, binary.BigEndian, all, t)
+	return buf.Bytes()
+}
+
+func TestPipeline(t *testing.T) {
+	stream := pipelineStream(20, t)
+	d, err := NewDecoder(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want [][]color.Color
+	for {
+		p, err := d.NextPage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		colors, err := p.ReadAllColors(make([]byte, p.LineSize()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, colors)
+	}
+
+	d, err = NewDecoder(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inFlight, maxInFlight int32
+	var got [][]color.Color
+	err = d.Pipeline(context.Background(), PipelineOptions{Workers: 4, MaxPending: 6},
+		func(i int, p *Page, data []byte) (interface{}, error) {
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				m := atomic.LoadInt32(&maxInFlight)
+				if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+					break
+				}
+			}
+			// Finish pages out of order.
+			time.Sleep(time.Duration(20-i) * time.Millisecond / 4)
+			colors, err := p.ParseColors(data)
+			if err != nil {
+				return nil, err
+			}
+			return colors[:p.Header.CUPS.Width*p.Header.CUPS.Height], nil
+		},
+		func(i int, p *Page, result interface{}) error {
+			atomic.AddInt32(&inFlight, -1)
+			if p.Header.NumCopies != i {
+				t.Errorf("got page %d at index %d", p.Header.NumCopies, i)
+			}
+			got = append(got, result.([]color.Color))
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pipeline returned different colors than sequential decoding")
+	}
+	if maxInFlight > 6 {
+		t.Errorf("%d pages were in flight, want at most 6", maxInFlight)
+	}
+}
+
+func TestPipelineErrors(t *testing.T) {
+	stream := pipelineStream(10, t)
+	process := func(i int, p *Page, data []byte) (interface{}, error) { return i, nil }
+
+	errOutput := errors.New("output failed")
+	d, _ := NewDecoder(bytes.NewReader(stream))
+	var outputs int
+	err := d.Pipeline(context.Background(), PipelineOptions{Workers: 2}, process,
+		func(i int, p *Page, result interface{}) error {
+			outputs++
+			if i == 3 {
+				return errOutput
+			}
+			return nil
+		})
+	if err != errOutput || outputs != 4 {
+		t.Errorf("got error %v after %d outputs, want %v after 4", err, outputs, errOutput)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d, _ = NewDecoder(bytes.NewReader(stream))
+	err = d.Pipeline(ctx, PipelineOptions{Workers: 2}, process,
+		func(i int, p *Page, result interface{}) error {
+			if i == 1 {
+				cancel()
+			}
+			return nil
+		})
+	if err != context.Canceled {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+
+	// All complete pages of a truncated stream are output.
+	d, _ = NewDecoder(bytes.NewReader(stream[:len(stream)-10]))
+	outputs = 0
+	err = d.Pipeline(context.Background(), PipelineOptions{}, process,
+		func(i int, p *Page, result interface{}) error {
+			outputs++
+			return nil
+		})
+	if !errors.Is(err, io.ErrUnexpectedEOF) || outputs != 9 {
+		t.Errorf("got error %v after %d outputs, want io.ErrUnexpectedEOF after 9", err, outputs)
+	}
+}
@@ -0,0 +1,276 @@
+package raster
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// PWGMediaClass is the value of Header.MediaClass that identifies
+// PWG Raster pages.
+const PWGMediaClass = "PwgRaster"
+
+const (
+	PWGQualityDefault = 0
+	PWGQualityDraft   = 3
+	PWGQualityNormal  = 4
+	PWGQualityHigh    = 5
+)
+
+func isPWG(version int, bo binary.ByteOrder, h *Header) bool {
+	return version == 2 && bo == binary.BigEndian && h.MediaClass == PWGMediaClass
+}
+
+// PWGHeader contains the fields of a PWG Raster page header that
+// are stored in the generic CUPSHeader.Integer slots and in
+// OutputType.
+type PWGHeader struct {
+	// PrintContentOptimize is stored in Header.OutputType.
+	PrintContentOptimize string
+	TotalPageCount       int
+	CrossFeedTransform   int
+	FeedTransform        int
+	ImageBoxLeft         int
+	ImageBoxTop          int
+	ImageBoxRight        int
+	ImageBoxBottom       int
+	// AlternatePrimary is an sRGB color, encoded as 0xRRGGBB.
+	AlternatePrimary int
+	PrintQuality     int
+	VendorIdentifier int
+	VendorLength     int
+	// VendorData holds VendorLength bytes of vendor data. PWG Raster
+	// stores it in place of CUPSHeader.Real and CUPSHeader.String.
+	VendorData []byte
+}
+
+// pwgVendorSize is the maximum size of the vendor data of a PWG Raster
+// page header: the size of CUPSHeader.Real and CUPSHeader.String.
+const pwgVendorSize = 16*4 + 16*64
+
+// PWG returns the PWG-specific interpretation of h. It doesn't check
+// that h actually is a PWG Raster header.
+func (h *Header) PWG() PWGHeader {
+	ints := &h.CUPS.Integer
+	return PWGHeader{
+		PrintContentOptimize: h.OutputType,
+		TotalPageCount:       ints[0],
+		// the transforms are signed
+		CrossFeedTransform: int(int32(ints[1])),
+		FeedTransform:      int(int32(ints[2])),
+		ImageBoxLeft:       ints[3],
+		ImageBoxTop:        ints[4],
+		ImageBoxRight:      ints[5],
+		ImageBoxBottom:     ints[6],
+		AlternatePrimary:   ints[7],
+		PrintQuality:       ints[8],
+		VendorIdentifier:   ints[14],
+		VendorLength:       ints[15],
+		VendorData:         []byte(h.vendorData),
+	}
+}
+
+// SetPWG stores the fields of pwg in h and sets h.MediaClass to
+// PWGMediaClass. If pwg has VendorData, the Encoder writes it in place
+// of the first bytes of h.CUPS.Real and h.CUPS.String. Vendor data of
+// more than 1088 bytes is truncated.
+func (h *Header) SetPWG(pwg PWGHeader) {
+	h.MediaClass = PWGMediaClass
+	h.OutputType = pwg.PrintContentOptimize
+	ints := &h.CUPS.Integer
+	ints[0] = pwg.TotalPageCount
+	ints[1] = int(uint32(pwg.CrossFeedTransform))
+	ints[2] = int(uint32(pwg.FeedTransform))
+	ints[3] = pwg.ImageBoxLeft
+	ints[4] = pwg.ImageBoxTop
+	ints[5] = pwg.ImageBoxRight
+	ints[6] = pwg.ImageBoxBottom
+	ints[7] = pwg.AlternatePrimary
+	ints[8] = pwg.PrintQuality
+	ints[14] = pwg.VendorIdentifier
+	ints[15] = pwg.VendorLength
+	if len(pwg.VendorData) > pwgVendorSize {
+		pwg.VendorData = pwg.VendorData[:pwgVendorSize]
+	}
+	h.vendorData = string(pwg.VendorData)
+}
+
+// ValidatePWG checks h against the requirements of PWG 5102.4 and
+// returns one FieldError per non-conforming field. It returns nil if
+// h is a valid PWG Raster header.
+func ValidatePWG(h *Header) []*FieldError {
+	var errs []*FieldError
+	report := func(field string, value interface{}, reason string) {
+		errs = append(errs, &FieldError{field, value, reason})
+	}
+	zero := func(field string, value int) {
+		if value != 0 {
+			report(field, value, "reserved, must be 0")
+		}
+	}
+	zeroBool := func(field string, value bool) {
+		if value {
+			report(field, value, "reserved, must be false")
+		}
+	}
+	zeroFloat := func(field string, value float32) {
+		if value != 0 {
+			report(field, value, "reserved, must be 0")
+		}
+	}
+	valid := func(field string, value interface{ IsValid() bool }) {
+		if !value.IsValid() {
+			report(field, value, "not a valid value")
+		}
+	}
+	between := func(field string, value, min, max int) {
+		if value < min || value > max {
+			report(field, value, fmt.Sprintf("must be between %d and %d", min, max))
+		}
+	}
+
+	if h.MediaClass != PWGMediaClass {
+		report("MediaClass", h.MediaClass, fmt.Sprintf("must be %q", PWGMediaClass))
+	}
+	zero("AdvanceDistance", h.AdvanceDistance)
+	zero("AdvanceMedia", int(h.AdvanceMedia))
+	zeroBool("Collate", h.Collate)
+	valid("CutMedia", h.CutMedia)
+	if h.HorizDPI <= 0 {
+		report("HorizDPI", h.HorizDPI, "must be positive")
+	}
+	if h.VertDPI <= 0 {
+		report("VertDPI", h.VertDPI, "must be positive")
+	}
+	zero("BoundingBox.Left", h.BoundingBox.Left)
+	zero("BoundingBox.Bottom", h.BoundingBox.Bottom)
+	zero("BoundingBox.Right", h.BoundingBox.Right)
+	zero("BoundingBox.Top", h.BoundingBox.Top)
+	valid("Jog", h.Jog)
+	valid("LeadingEdge", h.LeadingEdge)
+	zero("MarginLeft", h.MarginLeft)
+	zero("MarginBottom", h.MarginBottom)
+	zeroBool("ManualFeed", h.ManualFeed)
+	zeroBool("MirrorPrint", h.MirrorPrint)
+	zeroBool("NegativePrint", h.NegativePrint)
+	valid("Orientation", h.Orientation)
+	zeroBool("OutputFaceUp", h.OutputFaceUp)
+	if h.Width <= 0 {
+		report("Width", h.Width, "must be positive")
+	}
+	if h.Length <= 0 {
+		report("Length", h.Length, "must be positive")
+	}
+	zeroBool("Separations", h.Separations)
+	zeroBool("TraySwitch", h.TraySwitch)
+
+	c := &h.CUPS
+	if c.Width <= 0 {
+		report("CUPS.Width", c.Width, "must be positive")
+	}
+	if c.Height <= 0 {
+		report("CUPS.Height", c.Height, "must be positive")
+	}
+	zero("CUPS.MediaType", c.MediaType)
+	var colors int
+	switch c.ColorSpace {
+	case ColorSpaceBlack, ColorSpacesGray:
+		colors = 1
+		switch c.BitsPerColor {
+		case 1, 8, 16:
+		default:
+			report("CUPS.BitsPerColor", c.BitsPerColor, "must be 1, 8 or 16")
+		}
+	case ColorSpaceRGB, ColorSpacesRGB, ColorSpaceAdobeRGB:
+		colors = 3
+		if c.BitsPerColor != 8 && c.BitsPerColor != 16 {
+			report("CUPS.BitsPerColor", c.BitsPerColor, "must be 8 or 16")
+		}
+	case ColorSpaceCMYK:
+		colors = 4
+		if c.BitsPerColor != 8 && c.BitsPerColor != 16 {
+			report("CUPS.BitsPerColor", c.BitsPerColor, "must be 8 or 16")
+		}
+	default:
+		if c.ColorSpace >= ColorSpaceDevice1 && c.ColorSpace <= ColorSpaceDeviceF {
+			colors = c.ColorSpace.NumColors()
+			if c.BitsPerColor != 8 && c.BitsPerColor != 16 {
+				report("CUPS.BitsPerColor", c.BitsPerColor, "must be 8 or 16")
+			}
+		} else {
+			report("CUPS.ColorSpace", c.ColorSpace, "not permitted in PWG Raster")
+		}
+	}
+	if colors != 0 && c.NumColors != colors {
+		report("CUPS.NumColors", c.NumColors, fmt.Sprintf("must be %d for the color space", colors))
+	}
+	if c.BitsPerPixel != c.BitsPerColor*c.NumColors {
+		report("CUPS.BitsPerPixel", c.BitsPerPixel, "must be BitsPerColor * NumColors")
+	}
+	if want := (c.Width*c.BitsPerPixel + 7) / 8; c.BytesPerLine != want {
+		report("CUPS.BytesPerLine", c.BytesPerLine, fmt.Sprintf("must be %d", want))
+	}
+	if c.ColorOrder != ChunkyPixels {
+		report("CUPS.ColorOrder", c.ColorOrder, "must be ChunkyPixels")
+	}
+	zero("CUPS.Compression", c.Compression)
+	zero("CUPS.RowCount", c.RowCount)
+	zero("CUPS.RowFeed", c.RowFeed)
+	zero("CUPS.RowStep", c.RowStep)
+	zeroFloat("CUPS.BorderlessScalingFactor", c.BorderlessScalingFactor)
+	zeroFloat("CUPS.PageSize[0]", c.PageSize[0])
+	zeroFloat("CUPS.PageSize[1]", c.PageSize[1])
+	zeroFloat("CUPS.ImagingBBox.Left", c.ImagingBBox.Left)
+	zeroFloat("CUPS.ImagingBBox.Bottom", c.ImagingBBox.Bottom)
+	zeroFloat("CUPS.ImagingBBox.Right", c.ImagingBBox.Right)
+	zeroFloat("CUPS.ImagingBBox.Top", c.ImagingBBox.Top)
+
+	pwg := h.PWG()
+	if t := pwg.CrossFeedTransform; t != 1 && t != -1 {
+		report("CrossFeedTransform", t, "must be 1 or -1")
+	}
+	if t := pwg.FeedTransform; t != 1 && t != -1 {
+		report("FeedTransform", t, "must be 1 or -1")
+	}
+	if pwg.ImageBoxLeft > pwg.ImageBoxRight {
+		report("ImageBoxLeft", pwg.ImageBoxLeft, "must not be larger than ImageBoxRight")
+	}
+	if pwg.ImageBoxRight > c.Width {
+		report("ImageBoxRight", pwg.ImageBoxRight, "must not be larger than CUPS.Width")
+	}
+	if pwg.ImageBoxTop > pwg.ImageBoxBottom {
+		report("ImageBoxTop", pwg.ImageBoxTop, "must not be larger than ImageBoxBottom")
+	}
+	if pwg.ImageBoxBottom > c.Height {
+		report("ImageBoxBottom", pwg.ImageBoxBottom, "must not be larger than CUPS.Height")
+	}
+	if pwg.AlternatePrimary > 0xFFFFFF {
+		report("AlternatePrimary", pwg.AlternatePrimary, "must be a 24-bit sRGB color")
+	}
+	switch pwg.PrintQuality {
+	case PWGQualityDefault, PWGQualityDraft, PWGQualityNormal, PWGQualityHigh:
+	default:
+		report("PrintQuality", pwg.PrintQuality, "must be 0, 3, 4 or 5")
+	}
+	for i := 9; i < 14; i++ {
+		zero(fmt.Sprintf("CUPS.Integer[%d]", i), c.Integer[i])
+	}
+	// The PWG header stores up to 1088 bytes of vendor data where
+	// CUPS stores Real and String. The bytes that don't hold vendor
+	// data are reserved.
+	between("VendorLength", pwg.VendorLength, 0, pwgVendorSize)
+	for i, v := range c.Real {
+		if i*4 >= pwg.VendorLength {
+			zeroFloat(fmt.Sprintf("CUPS.Real[%d]", i), v)
+		}
+	}
+	for i, v := range c.String {
+		if 64+i*64 >= pwg.VendorLength && v != "" {
+			report(fmt.Sprintf("CUPS.String[%d]", i), strconv.Quote(v), "reserved, must be empty")
+		}
+	}
+	if c.MarkerType != "" {
+		report("CUPS.MarkerType", strconv.Quote(c.MarkerType), "reserved, must be empty")
+	}
+	return errs
+}
@@ -0,0 +1,195 @@
+package raster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPWGFlavor(t *testing.T) {
+	var tests = []struct {
+		file   string
+		flavor Flavor
+	}{
+		{"raster", FlavorPWG},
+		{"two_pages", FlavorPWG},
+		{"gradient_chunked_k_8_8", FlavorCUPS},
+	}
+	for _, tt := range tests {
+		f := open(tt.file, t)
+		defer f.Close()
+		d, err := NewDecoder(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := d.NextPage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Flavor() != tt.flavor {
+			t.Errorf("%s: got flavor %d, want %d", tt.file, d.Flavor(), tt.flavor)
+		}
+		if tt.flavor != FlavorPWG {
+			continue
+		}
+		if errs := ValidatePWG(p.Header); errs != nil {
+			t.Errorf("%s: ValidatePWG reported %v, want no errors", tt.file, errs)
+		}
+		pwg := p.Header.PWG()
+		if pwg.CrossFeedTransform != 1 || pwg.FeedTransform != 1 {
+			t.Errorf("%s: got transforms %d/%d, want 1/1", tt.file, pwg.CrossFeedTransform, pwg.FeedTransform)
+		}
+		if pwg.AlternatePrimary != 0xFFFFFF {
+			t.Errorf("%s: got AlternatePrimary %#x, want 0xffffff", tt.file, pwg.AlternatePrimary)
+		}
+	}
+}
+
+func TestPWGRoundTrip(t *testing.T) {
+	h := &Header{
+		HorizDPI: 300,
+		VertDPI:  300,
+		Width:    612,
+		Length:   792,
+	}
+	h.CUPS.Width = 2550
+	h.CUPS.Height = 3300
+	h.CUPS.BitsPerColor = 8
+	h.CUPS.BitsPerPixel = 24
+	h.CUPS.BytesPerLine = 2550 * 3
+	h.CUPS.ColorSpace = ColorSpacesRGB
+	h.CUPS.NumColors = 3
+	h.CUPS.PageSizeName = "na_letter_8.5x11in"
+	want := PWGHeader{
+		PrintContentOptimize: "photo",
+		TotalPageCount:       3,
+		CrossFeedTransform:   -1,
+		FeedTransform:        1,
+		ImageBoxLeft:         10,
+		ImageBoxTop:          20,
+		ImageBoxRight:        2540,
+		ImageBoxBottom:       3280,
+		AlternatePrimary:     0xFF0000,
+		PrintQuality:         PWGQualityHigh,
+		VendorIdentifier:     0x1234,
+		VendorLength:         100,
+		VendorData:           bytes.Repeat([]byte{0, 0xFF, 'x', 0x80}, 25),
+	}
+	h.SetPWG(want)
+	if errs := ValidatePWG(h); errs != nil {
+		t.Fatalf("ValidatePWG reported %v, want no errors", errs)
+	}
+
+	// Headers survive a round trip through JSON, vendor data
+	// included, and can be encoded again.
+	b, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jh Header
+	if err := json.Unmarshal(b, &jh); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(jh, *h) {
+		t.Errorf("header didn't survive JSON round trip:\ngot  %+v\nwant %+v", jh.PWG(), h.PWG())
+	}
+
+	var buf bytes.Buffer
+	e, err := NewEncoder(&buf, 2, binary.BigEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WritePage(&jh); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDecoder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := d.NextPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Flavor() != FlavorPWG {
+		t.Errorf("got flavor %d, want FlavorPWG", d.Flavor())
+	}
+	if got := p.Header.PWG(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestValidatePWG(t *testing.T) {
+	f := open("raster", t)
+	defer f.Close()
+	d, err := NewDecoder(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := d.NextPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := *p.Header
+	h.MediaClass = "Custom"
+	h.MarginLeft = 3
+	h.CUPS.ColorOrder = PlanarPixels
+	h.CUPS.BytesPerLine++
+	h.CUPS.Real[4] = 1
+	h.CUPS.Integer[8] = 2
+
+	want := []string{
+		"MediaClass",
+		"MarginLeft",
+		"CUPS.BytesPerLine",
+		"CUPS.ColorOrder",
+		"PrintQuality",
+		"CUPS.Real[4]",
+	}
+	errs := ValidatePWG(&h)
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors (%v), want %d", len(errs), errs, len(want))
+	}
+	for i, err := range errs {
+		if err.Field != want[i] {
+			t.Errorf("error %d is for field %s, want %s", i, err.Field, want[i])
+		}
+	}
+}
+
+func TestValidatePWGVendorData(t *testing.T) {
+	f := open("raster", t)
+	defer f.Close()
+	d, err := NewDecoder(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := d.NextPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := *p.Header
+	pwg := h.PWG()
+	pwg.VendorLength = 72
+	h.SetPWG(pwg)
+	// Vendor data occupies Real and the start of String[0].
+	h.CUPS.Real[15] = 1
+	h.CUPS.String[0] = "vendor"
+	if errs := ValidatePWG(&h); errs != nil {
+		t.Errorf("ValidatePWG reported %v for vendor data, want no errors", errs)
+	}
+
+	h.CUPS.String[1] = "reserved"
+	h.CUPS.MarkerType = "reserved"
+	pwg.VendorLength = 60
+	h.SetPWG(pwg)
+	want := []string{"CUPS.Real[15]", "CUPS.String[0]", "CUPS.String[1]", "CUPS.MarkerType"}
+	var fields []string
+	for _, err := range ValidatePWG(&h) {
+		fields = append(fields, err.Field)
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("ValidatePWG reported problems with %v, want %v", fields, want)
+	}
+}
@@ -1,102 +1,131 @@
 package raster
 
-import "image/color"
+import (
+	"encoding/binary"
+	"image/color"
+	"math"
+)
+
+// AdvanceMedia specifies when to advance the media, via
+// Header.AdvanceMedia.
+type AdvanceMedia int
 
 const (
-	AdvanceNever     = 0
-	AdvanceAfterFile = 1
-	AdvanceAfterJob  = 2
-	AdvanceAfterSet  = 3
-	AdvanceAfterPage = 4
+	AdvanceNever     AdvanceMedia = 0
+	AdvanceAfterFile AdvanceMedia = 1
+	AdvanceAfterJob  AdvanceMedia = 2
+	AdvanceAfterSet  AdvanceMedia = 3
+	AdvanceAfterPage AdvanceMedia = 4
 )
 
+// CutMedia specifies when to cut the media, via Header.CutMedia.
+type CutMedia int
+
 const (
-	CutNever     = 0
-	CutAfterFile = 1
-	CutAfterJob  = 2
-	CutAfterSet  = 3
-	CutAfterPage = 4
+	CutNever     CutMedia = 0
+	CutAfterFile CutMedia = 1
+	CutAfterJob  CutMedia = 2
+	CutAfterSet  CutMedia = 3
+	CutAfterPage CutMedia = 4
 )
 
+// Jog specifies when to shift the position of output pages, via
+// Header.Jog.
+type Jog int
+
 const (
-	JogNever     = 0
-	JogAfterFile = 1
-	JogAfterJob  = 2
-	JogAfterSet  = 3
+	JogNever     Jog = 0
+	JogAfterFile Jog = 1
+	JogAfterJob  Jog = 2
+	JogAfterSet  Jog = 3
 )
 
+// LeadingEdge is the edge of the media that is fed into the printer
+// first.
+type LeadingEdge int
+
 const (
-	EdgeTop    = 0
-	EdgeRight  = 1
-	EdgeBottom = 2
-	EdgeLeft   = 3
+	EdgeTop    LeadingEdge = 0
+	EdgeRight  LeadingEdge = 1
+	EdgeBottom LeadingEdge = 2
+	EdgeLeft   LeadingEdge = 3
 )
 
+// Orientation is the rotation of the page on the media.
+type Orientation int
+
 const (
-	RotateNone             = 0
-	RotateCounterClockwise = 1
-	RotateUpsideDown       = 2
-	RotateClockwise        = 3
+	RotateNone             Orientation = 0
+	RotateCounterClockwise Orientation = 1
+	RotateUpsideDown       Orientation = 2
+	RotateClockwise        Orientation = 3
 )
 
+// ColorOrder specifies how the colors of pixels are arranged in
+// the raster data.
+type ColorOrder int
+
 const (
-	ChunkyPixels = 0
-	BandedPixels = 1
-	PlanarPixels = 2
+	ChunkyPixels ColorOrder = 0
+	BandedPixels ColorOrder = 1
+	PlanarPixels ColorOrder = 2
 )
 
+// ColorSpace is the color space of the raster data.
+type ColorSpace int
+
 const (
-	ColorSpaceGray     = 0
-	ColorSpaceRGB      = 1
-	ColorSpaceRGBA     = 2
-	ColorSpaceBlack    = 3
-	ColorSpaceCMY      = 4
-	ColorSpaceYMC      = 5
-	ColorSpaceCMYK     = 6
-	ColorSpaceYMCK     = 7
-	ColorSpaceKCMY     = 8
-	ColorSpaceKCMYcm   = 9
-	ColorSpaceGMCK     = 10
-	ColorSpaceGMCS     = 11
-	ColorSpaceWHITE    = 12
-	ColorSpaceGOLD     = 13
-	ColorSpaceSILVER   = 14
-	ColorSpaceCIEXYZ   = 15
-	ColorSpaceCIELab   = 16
-	ColorSpaceRGBW     = 17
-	ColorSpacesGray    = 18
-	ColorSpacesRGB     = 19
-	ColorSpaceAdobeRGB = 20
-	ColorSpaceICC1     = 32
-	ColorSpaceICC2     = 33
-	ColorSpaceICC3     = 34
-	ColorSpaceICC4     = 35
-	ColorSpaceICC5     = 36
-	ColorSpaceICC6     = 37
-	ColorSpaceICC7     = 38
-	ColorSpaceICC8     = 39
-	ColorSpaceICC9     = 40
-	ColorSpaceICCA     = 41
-	ColorSpaceICCB     = 42
-	ColorSpaceICCC     = 43
-	ColorSpaceICCD     = 44
-	ColorSpaceICCE     = 45
-	ColorSpaceICCF     = 46
-	ColorSpaceDevice1  = 48
-	ColorSpaceDevice2  = 49
-	ColorSpaceDevice3  = 50
-	ColorSpaceDevice4  = 51
-	ColorSpaceDevice5  = 52
-	ColorSpaceDevice6  = 53
-	ColorSpaceDevice7  = 54
-	ColorSpaceDevice8  = 55
-	ColorSpaceDevice9  = 56
-	ColorSpaceDeviceA  = 57
-	ColorSpaceDeviceB  = 58
-	ColorSpaceDeviceC  = 59
-	ColorSpaceDeviceD  = 60
-	ColorSpaceDeviceE  = 61
-	ColorSpaceDeviceF  = 62
+	ColorSpaceGray     ColorSpace = 0
+	ColorSpaceRGB      ColorSpace = 1
+	ColorSpaceRGBA     ColorSpace = 2
+	ColorSpaceBlack    ColorSpace = 3
+	ColorSpaceCMY      ColorSpace = 4
+	ColorSpaceYMC      ColorSpace = 5
+	ColorSpaceCMYK     ColorSpace = 6
+	ColorSpaceYMCK     ColorSpace = 7
+	ColorSpaceKCMY     ColorSpace = 8
+	ColorSpaceKCMYcm   ColorSpace = 9
+	ColorSpaceGMCK     ColorSpace = 10
+	ColorSpaceGMCS     ColorSpace = 11
+	ColorSpaceWHITE    ColorSpace = 12
+	ColorSpaceGOLD     ColorSpace = 13
+	ColorSpaceSILVER   ColorSpace = 14
+	ColorSpaceCIEXYZ   ColorSpace = 15
+	ColorSpaceCIELab   ColorSpace = 16
+	ColorSpaceRGBW     ColorSpace = 17
+	ColorSpacesGray    ColorSpace = 18
+	ColorSpacesRGB     ColorSpace = 19
+	ColorSpaceAdobeRGB ColorSpace = 20
+	ColorSpaceICC1     ColorSpace = 32
+	ColorSpaceICC2     ColorSpace = 33
+	ColorSpaceICC3     ColorSpace = 34
+	ColorSpaceICC4     ColorSpace = 35
+	ColorSpaceICC5     ColorSpace = 36
+	ColorSpaceICC6     ColorSpace = 37
+	ColorSpaceICC7     ColorSpace = 38
+	ColorSpaceICC8     ColorSpace = 39
+	ColorSpaceICC9     ColorSpace = 40
+	ColorSpaceICCA     ColorSpace = 41
+	ColorSpaceICCB     ColorSpace = 42
+	ColorSpaceICCC     ColorSpace = 43
+	ColorSpaceICCD     ColorSpace = 44
+	ColorSpaceICCE     ColorSpace = 45
+	ColorSpaceICCF     ColorSpace = 46
+	ColorSpaceDevice1  ColorSpace = 48
+	ColorSpaceDevice2  ColorSpace = 49
+	ColorSpaceDevice3  ColorSpace = 50
+	ColorSpaceDevice4  ColorSpace = 51
+	ColorSpaceDevice5  ColorSpace = 52
+	ColorSpaceDevice6  ColorSpace = 53
+	ColorSpaceDevice7  ColorSpace = 54
+	ColorSpaceDevice8  ColorSpace = 55
+	ColorSpaceDevice9  ColorSpace = 56
+	ColorSpaceDeviceA  ColorSpace = 57
+	ColorSpaceDeviceB  ColorSpace = 58
+	ColorSpaceDeviceC  ColorSpace = 59
+	ColorSpaceDeviceD  ColorSpace = 60
+	ColorSpaceDeviceE  ColorSpace = 61
+	ColorSpaceDeviceF  ColorSpace = 62
 )
 
 type BoundingBox struct {
@@ -121,16 +150,16 @@ type Header struct {
 	MediaType       string
 	OutputType      string
 	AdvanceDistance int
-	AdvanceMedia    int
+	AdvanceMedia    AdvanceMedia
 	Collate         bool
-	CutMedia        int
+	CutMedia        CutMedia
 	Duplex          bool
 	HorizDPI        int
 	VertDPI         int
 	BoundingBox     BoundingBox
 	InsertSheet     bool
-	Jog             int
-	LeadingEdge     int
+	Jog             Jog
+	LeadingEdge     LeadingEdge
 	MarginLeft      int
 	MarginBottom    int
 	ManualFeed      bool
@@ -139,7 +168,7 @@ type Header struct {
 	MirrorPrint     bool
 	NegativePrint   bool
 	NumCopies       int
-	Orientation     int
+	Orientation     Orientation
 	OutputFaceUp    bool
 	Width           int
 	Length          int
@@ -147,6 +176,10 @@ type Header struct {
 	TraySwitch      bool
 	Tumble          bool
 	CUPS            CUPSHeader
+
+	// vendorData holds the vendor data of PWG Raster pages, which
+	// is stored in place of CUPS.Real and CUPS.String.
+	vendorData string
 }
 
 type CUPSHeader struct {
@@ -157,8 +190,8 @@ type CUPSHeader struct {
 	BitsPerColor int
 	BitsPerPixel int
 	BytesPerLine int
-	ColorOrder   int
-	ColorSpace   int
+	ColorOrder   ColorOrder
+	ColorSpace   ColorSpace
 	Compression  int
 	RowCount     int
 	RowFeed      int
@@ -180,12 +213,44 @@ type CUPSHeader struct {
 // ParseColors parses b and returns the colors stored in it, one per
 // pixel.
 //
-// It currently supports the following color spaces and bit depths,
-// although more might be added later:
+// All color spaces are supported with 1, 2, 4, 8 and 16 bits per
+// color. Depending on the color space, the following types are
+// returned for up to 8 and for 16 bits per color respectively:
+//
+//   - ColorSpaceGray, ColorSpacesGray, ColorSpaceBlack,
+//     ColorSpaceWHITE, ColorSpaceGOLD, ColorSpaceSILVER ->
+//     color.Gray, color.Gray16
+//   - ColorSpaceRGB, ColorSpacesRGB, ColorSpaceAdobeRGB ->
+//     color.RGBA, color.RGBA64
+//   - ColorSpaceRGBA -> color.NRGBA, color.NRGBA64
+//   - ColorSpaceRGBW -> RGBW, RGBW64
+//   - ColorSpaceCMY, ColorSpaceYMC, ColorSpaceCMYK,
+//     ColorSpaceYMCK, ColorSpaceKCMY, ColorSpaceGMCK,
+//     ColorSpaceGMCS -> color.CMYK, CMYK64
+//   - ColorSpaceKCMYcm -> KCMYcm with 1 bit per color, color.CMYK
+//     and CMYK64 otherwise
+//   - ColorSpaceCIEXYZ -> CIEXYZ
+//   - ColorSpaceCIELab -> CIELab
+//   - ColorSpaceICC1 through ColorSpaceICCF,
+//     ColorSpaceDevice1 through ColorSpaceDeviceF -> DeviceN
 //
-// 	- 1-bit, ColorSpaceBlack -> color.Gray
-// 	- 8-bit, ColorSpaceBlack -> color.Gray
-// 	- 8-bit, ColorSpaceCMYK -> color.CMYK
+// Values with fewer than 8 bits are scaled to the full 8-bit range.
+// 16-bit values are stored in the byte order of the stream.
+//
+// With ChunkyPixels and BandedPixels, b may contain any number of
+// lines. With PlanarPixels, each line returned by ReadLine only
+// contains a single color, and all planes of the page are stored
+// one after the other. b must then contain the same number of lines
+// of every plane, as is the case for data returned by ReadAll.
+// ReadLineColors and ReadAllColors compose pixels of planar pages
+// automatically.
+//
+// Black and the ink colors white, gold and silver store ink coverage
+// and are inverted into gray levels. CMY and YMC have no black
+// component. Colors are reordered to CMYK where necessary; with
+// GMCK and GMCS, gold is stored as yellow, and silver as black. With
+// more than one bit per color, CUPS uses only the black, cyan,
+// magenta and yellow inks of KCMYcm.
 //
 // Note that b might contain data for more colors than are actually
 // present. This happens when data is stored with less than 8 bits per
@@ -198,59 +263,265 @@ type CUPSHeader struct {
 // may be used, which return slices of colors and truncate them as
 // needed.
 func (p *Page) ParseColors(b []byte) ([]color.Color, error) {
-	// TODO support banded and planar
-	if p.Header.CUPS.ColorOrder != ChunkyPixels {
+	h := &p.Header.CUPS
+	n := numColors(h)
+	if n == 0 {
 		return nil, ErrUnsupported
 	}
-	switch p.Header.CUPS.ColorSpace {
-	case ColorSpaceBlack:
-		return p.parseColorsBlack(b)
-	case ColorSpaceCMYK:
-		return p.parseColorsCMYK(b)
+	switch h.BitsPerColor {
+	case 1, 2, 4, 8, 16:
 	default:
 		return nil, ErrUnsupported
 	}
+	switch h.ColorOrder {
+	case ChunkyPixels:
+		return p.parseChunky(b, n)
+	case BandedPixels:
+		return p.parseBanded(b, n)
+	case PlanarPixels:
+		return p.parsePlanar(b, n)
+	default:
+		return nil, ErrInvalidFormat
+	}
+}
+
+func (p *Page) parseChunky(b []byte, n int) ([]color.Color, error) {
+	l, err := p.chunkySamples(b, n)
+	if err != nil {
+		return nil, err
+	}
+	return p.appendColors(nil, &l), nil
 }
 
-func (p *Page) parseColorsBlack(b []byte) ([]color.Color, error) {
-	// TODO support all depths
+func (p *Page) parseBanded(b []byte, n int) ([]color.Color, error) {
+	// Each line consists of one band per color.
+	bpl := p.Header.CUPS.BytesPerLine
+	if bpl == 0 || bpl%n != 0 || len(b)%bpl != 0 {
+		return nil, ErrInvalidFormat
+	}
+	band := bpl / n
 	var colors []color.Color
-	switch p.Header.CUPS.BitsPerColor {
-	case 1:
-		for _, packet := range b {
-			for i := uint(0); i < 8; i++ {
-				if packet<<i&128 == 0 {
-					colors = append(colors, color.Gray{255})
-				} else {
-					colors = append(colors, color.Gray{0})
-				}
-			}
+	sep := make([][]byte, n)
+	for line := 0; line < len(b); line += bpl {
+		for i := range sep {
+			sep[i] = b[line+i*band : line+(i+1)*band]
 		}
-	case 8:
-		for _, v := range b {
-			colors = append(colors, color.Gray{Y: 255 - v})
+		var err error
+		colors, err = p.parseSeparated(colors, sep)
+		if err != nil {
+			return nil, err
 		}
-	default:
-		return nil, ErrUnsupported
 	}
 	return colors, nil
 }
 
-func (p *Page) parseColorsCMYK(b []byte) ([]color.Color, error) {
-	if p.Header.CUPS.BitsPerColor != 8 {
-		return nil, ErrUnsupported
-	}
-	if len(b)%4 != 0 || len(b) < 4 {
+func (p *Page) parsePlanar(b []byte, n int) ([]color.Color, error) {
+	// b consists of one plane per color, each containing the same
+	// number of lines.
+	bpl := p.Header.CUPS.BytesPerLine
+	if bpl == 0 || len(b)%(n*bpl) != 0 {
 		return nil, ErrInvalidFormat
 	}
-	var colors []color.Color
-	for i := 0; i < len(b); i += 4 {
-		// TODO does cups have a byte order for colors in a pixel and
-		// do we need to swap bytes?
-		c := color.CMYK{C: b[i], M: b[i+1], Y: b[i+2], K: b[i+3]}
-		colors = append(colors, c)
+	plane := len(b) / n
+	sep := make([][]byte, n)
+	for i := range sep {
+		sep[i] = b[i*plane : (i+1)*plane]
+	}
+	return p.parseSeparated(nil, sep)
+}
+
+// parseSeparated parses colors whose components are stored in
+// separate slices of equal length, one per color, and appends them
+// to colors.
+func (p *Page) parseSeparated(colors []color.Color, sep [][]byte) ([]color.Color, error) {
+	l, err := p.separatedSamples(sep)
+	if err != nil {
+		return nil, err
+	}
+	return p.appendColors(colors, &l), nil
+}
+
+// appendColors appends the colors of all pixels in l to colors.
+func (p *Page) appendColors(colors []color.Color, l *lineSamples) []color.Color {
+	s := make([]uint16, l.n)
+	s8 := make([]uint8, l.n)
+	if colors == nil {
+		colors = make([]color.Color, 0, l.samples)
+	}
+	for x := 0; x < l.samples; x++ {
+		l.get(x, s)
+		colors = append(colors, p.pixel(s, s8))
+	}
+	return colors
+}
+
+// pixel returns the color of a pixel with the samples s. s8 is used
+// as scratch space and must be as large as s.
+func (p *Page) pixel(s []uint16, s8 []uint8) color.Color {
+	bpc := p.Header.CUPS.BitsPerColor
+	switch {
+	case bpc == 16:
+		return color16(p.Header.CUPS.ColorSpace, s)
+	case bpc == 1 && p.Header.CUPS.ColorSpace == ColorSpaceKCMYcm:
+		return KCMYcm{
+			K: s[0] == 1, C: s[1] == 1, M: s[2] == 1,
+			Y: s[3] == 1, LC: s[4] == 1, LM: s[5] == 1,
+		}
+	default:
+		max := uint32(1)<<uint(bpc) - 1
+		for i, v := range s {
+			s8[i] = uint8(uint32(v) * 255 / max)
+		}
+		return color8(p.Header.CUPS.ColorSpace, s8)
+	}
+}
+
+// byteOrder returns the byte order of 16-bit samples.
+func (p *Page) byteOrder() binary.ByteOrder {
+	if p.dec == nil {
+		return binary.BigEndian
+	}
+	return p.dec.bo
+}
+
+// white returns the value of the bytes of a white line: no colorant
+// for subtractive color spaces, and full intensity for all others.
+func (p *Page) white() byte {
+	if p.Header.CUPS.ColorSpace.IsSubtractive() {
+		return 0
+	}
+	return 0xFF
+}
+
+// sample returns the value with the given number of bits starting
+// at bit offset off in b. Values with fewer than 8 bits never cross
+// byte boundaries.
+func sample(b []byte, off, bits int, bo binary.ByteOrder) uint16 {
+	switch bits {
+	case 16:
+		return bo.Uint16(b[off/8:])
+	case 8:
+		return uint16(b[off/8])
+	default:
+		shift := uint(8 - off%8 - bits)
+		return uint16(b[off/8]>>shift) & (1<<uint(bits) - 1)
+	}
+}
+
+// numColors returns the number of colors per pixel, or 0 if the
+// color space is unknown.
+func numColors(h *CUPSHeader) int {
+	return h.ColorSpace.ColorsPerPixel(h.BitsPerColor)
+}
+
+// numLines returns the number of lines of image data in a page.
+// Planar pages store Height lines per color.
+func numLines(h *CUPSHeader) int {
+	if h.ColorOrder != PlanarPixels {
+		return h.Height
+	}
+	n := numColors(h)
+	if n == 0 {
+		n = h.NumColors
+	}
+	if n == 0 {
+		n = 1
+	}
+	return h.Height * n
+}
+
+// color8 returns the color of a pixel with 8 bits per color. s
+// contains exactly numColors values.
+func color8(cs ColorSpace, s []uint8) color.Color {
+	switch cs {
+	case ColorSpaceGray, ColorSpacesGray:
+		return color.Gray{Y: s[0]}
+	case ColorSpaceBlack, ColorSpaceWHITE, ColorSpaceGOLD, ColorSpaceSILVER:
+		return color.Gray{Y: 255 - s[0]}
+	case ColorSpaceRGB, ColorSpacesRGB, ColorSpaceAdobeRGB:
+		return color.RGBA{R: s[0], G: s[1], B: s[2], A: 255}
+	case ColorSpaceRGBA:
+		return color.NRGBA{R: s[0], G: s[1], B: s[2], A: s[3]}
+	case ColorSpaceRGBW:
+		return RGBW{R: s[0], G: s[1], B: s[2], W: s[3]}
+	case ColorSpaceCMY:
+		return color.CMYK{C: s[0], M: s[1], Y: s[2]}
+	case ColorSpaceYMC:
+		return color.CMYK{C: s[2], M: s[1], Y: s[0]}
+	case ColorSpaceCMYK:
+		return color.CMYK{C: s[0], M: s[1], Y: s[2], K: s[3]}
+	case ColorSpaceYMCK:
+		return color.CMYK{C: s[2], M: s[1], Y: s[0], K: s[3]}
+	case ColorSpaceKCMY, ColorSpaceKCMYcm:
+		return color.CMYK{C: s[1], M: s[2], Y: s[3], K: s[0]}
+	case ColorSpaceGMCK, ColorSpaceGMCS:
+		return color.CMYK{C: s[2], M: s[1], Y: s[0], K: s[3]}
+	case ColorSpaceCIEXYZ:
+		// CUPS scales XYZ values from [0, 1.1] to [0, 255]
+		return CIEXYZ{
+			X: float64(s[0]) / 231.8181,
+			Y: float64(s[1]) / 231.8181,
+			Z: float64(s[2]) / 231.8181,
+		}
+	case ColorSpaceCIELab:
+		return CIELab{
+			L: float64(s[0]) / 2.55,
+			A: float64(s[1]) - 128,
+			B: float64(s[2]) - 128,
+		}
+	default:
+		// ICC and DeviceN
+		v := make([]uint16, len(s))
+		for i, c := range s {
+			v[i] = uint16(c) * 0x101
+		}
+		return DeviceN{Values: v}
+	}
+}
+
+// color16 returns the color of a pixel with 16 bits per color. s
+// contains exactly numColors values.
+func color16(cs ColorSpace, s []uint16) color.Color {
+	switch cs {
+	case ColorSpaceGray, ColorSpacesGray:
+		return color.Gray16{Y: s[0]}
+	case ColorSpaceBlack, ColorSpaceWHITE, ColorSpaceGOLD, ColorSpaceSILVER:
+		return color.Gray16{Y: 0xffff - s[0]}
+	case ColorSpaceRGB, ColorSpacesRGB, ColorSpaceAdobeRGB:
+		return color.RGBA64{R: s[0], G: s[1], B: s[2], A: 0xffff}
+	case ColorSpaceRGBA:
+		return color.NRGBA64{R: s[0], G: s[1], B: s[2], A: s[3]}
+	case ColorSpaceRGBW:
+		return RGBW64{R: s[0], G: s[1], B: s[2], W: s[3]}
+	case ColorSpaceCMY:
+		return CMYK64{C: s[0], M: s[1], Y: s[2]}
+	case ColorSpaceYMC:
+		return CMYK64{C: s[2], M: s[1], Y: s[0]}
+	case ColorSpaceCMYK:
+		return CMYK64{C: s[0], M: s[1], Y: s[2], K: s[3]}
+	case ColorSpaceYMCK:
+		return CMYK64{C: s[2], M: s[1], Y: s[0], K: s[3]}
+	case ColorSpaceKCMY, ColorSpaceKCMYcm:
+		return CMYK64{C: s[1], M: s[2], Y: s[3], K: s[0]}
+	case ColorSpaceGMCK, ColorSpaceGMCS:
+		return CMYK64{C: s[2], M: s[1], Y: s[0], K: s[3]}
+	case ColorSpaceCIEXYZ:
+		// CUPS scales XYZ values from [0, 1.1] to [0, 65535]
+		return CIEXYZ{
+			X: float64(s[0]) / 59577.2727,
+			Y: float64(s[1]) / 59577.2727,
+			Z: float64(s[2]) / 59577.2727,
+		}
+	case ColorSpaceCIELab:
+		return CIELab{
+			L: float64(s[0]) / 655.35,
+			A: float64(s[1])/256 - 128,
+			B: float64(s[2])/256 - 128,
+		}
+	default:
+		// ICC and DeviceN
+		return DeviceN{Values: append([]uint16(nil), s...)}
 	}
-	return colors, nil
 }
 
 // LineSize returns the size of a single line, in bytes.
@@ -259,6 +530,12 @@ func (p *Page) LineSize() int {
 }
 
 // Size returns the size of the unread portion of the page, in bytes.
+// If the size of the page, as claimed by its header, doesn't fit in
+// an int, Size returns math.MaxInt.
 func (p *Page) Size() int {
-	return p.LineSize() * p.UnreadLines()
+	l, n := p.LineSize(), p.UnreadLines()
+	if l > 0 && n > math.MaxInt/l {
+		return math.MaxInt
+	}
+	return l * n
 }
@@ -0,0 +1,187 @@
+package raster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+)
+
+// In recovery mode, the decoder resynchronizes with a damaged stream
+// by scanning it for data that decodes to a plausible page header.
+// CUPS raster streams have no sync word per page, so plausibility is
+// judged by the structure of the header: its strings must be
+// NUL-terminated and printable, its booleans 0 or 1, its enumerated
+// values known, and its layout consistent according to
+// Header.Validate.
+
+const (
+	headerSizeV1  = 4*64 + 41*4
+	headerSizeV2  = headerSizeV1 + 40*4 + 19*64
+	headerSizeURF = 32
+)
+
+// Skipped returns the number of bytes that were skipped while
+// scanning for page headers in recovery mode.
+func (d *Decoder) Skipped() int64 {
+	return d.skipped
+}
+
+// Synthesized returns the number of white lines that ReadLine
+// returned in place of missing or corrupt lines, when
+// DecoderOptions.FillMissingLines is set.
+func (p *Page) Synthesized() int {
+	return p.synthesized
+}
+
+// Err returns the error that occurred while reading the page's image
+// data, if any. When DecoderOptions.FillMissingLines is set, it
+// explains why lines were synthesized.
+func (p *Page) Err() error {
+	return p.err
+}
+
+// fillLine fills b with a white line and counts it as synthesized.
+func (p *Page) fillLine(b []byte) {
+	white := p.white()
+	b = b[:p.Header.CUPS.BytesPerLine]
+	for i := range b {
+		b[i] = white
+	}
+	p.synthesized++
+}
+
+func (d *Decoder) headerSize() int {
+	switch d.version {
+	case 1:
+		return headerSizeV1
+	case 2, 3:
+		return headerSizeV2
+	case versionURF:
+		return headerSizeURF
+	default:
+		// can't happen, NewDecoder rejects unknown versions
+		panic("impossible")
+	}
+}
+
+// scanHeader reads from the stream until it finds a plausible page
+// header, and returns it. It returns io.EOF if the stream ends before
+// a header is found. On other errors, the bytes that haven't been
+// scanned completely are pushed back, so that scanning can resume
+// with them.
+func (d *Decoder) scanHeader(ctx context.Context) (*Header, error) {
+	size := d.headerSize()
+	buf := make([]byte, 0, 64*1024)
+	for {
+		if err := ctx.Err(); err != nil {
+			d.r.unreadBytes(buf)
+			return nil, err
+		}
+		n, err := io.ReadAtLeast(d.r, buf[len(buf):cap(buf)], 1)
+		buf = buf[:len(buf)+n]
+		for i := 0; i+size <= len(buf); i++ {
+			if !d.maybeHeader(buf[i : i+size]) {
+				continue
+			}
+			if h := d.tryHeader(buf[i : i+size]); h != nil {
+				d.r.unreadBytes(buf[i+size:])
+				d.skipped += int64(i)
+				return h, nil
+			}
+		}
+		if err == io.EOF {
+			d.skipped += int64(len(buf))
+			return nil, io.EOF
+		}
+		if err != nil {
+			d.r.unreadBytes(buf)
+			return nil, err
+		}
+		// Keep the bytes that may still be the start of a header.
+		if keep := size - 1; len(buf) > keep {
+			d.skipped += int64(len(buf) - keep)
+			buf = buf[:copy(buf, buf[len(buf)-keep:])]
+		}
+	}
+}
+
+// maybeHeader quickly checks whether b might be a page header, before
+// decoding it.
+func (d *Decoder) maybeHeader(b []byte) bool {
+	if d.version == versionURF {
+		return b[1] < byte(len(urfColorSpaces)) && b[2] <= 3 && b[3] <= URFQualityHigh
+	}
+	for i := 0; i < 4; i++ {
+		s := b[i*64 : (i+1)*64]
+		end := bytes.IndexByte(s, 0)
+		if end < 0 {
+			return false
+		}
+		for _, c := range s[:end] {
+			if c < 0x20 || c > 0x7E {
+				return false
+			}
+		}
+	}
+	// Collate, Duplex, InsertSheet, ManualFeed, MirrorPrint,
+	// NegativePrint, OutputFaceUp, Separations, TraySwitch and Tumble
+	bools := []int{2, 4, 11, 16, 19, 20, 23, 26, 27, 28}
+	for _, i := range bools {
+		if v := d.bo.Uint32(b[4*64+i*4:]); v > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// tryHeader decodes b as a page header and returns it if it is
+// plausible.
+func (d *Decoder) tryHeader(b []byte) *Header {
+	t := &Decoder{
+		r:        &countingReader{r: bytes.NewReader(b)},
+		bo:       d.bo,
+		version:  d.version,
+		urfPages: d.urfPages,
+	}
+	h, err := t.decodeHeader()
+	if err != nil || plausible(h) != nil {
+		return nil
+	}
+	return h
+}
+
+// plausible checks h like Header.Validate does, and additionally
+// checks fields that have no bearing on decoding but that are
+// unlikely to be wrong in a genuine header.
+func plausible(h *Header) []*FieldError {
+	errs := h.Validate()
+	report := func(field string, value interface{}, reason string) {
+		errs = append(errs, &FieldError{field, value, reason})
+	}
+	valid := func(field string, value interface{ IsValid() bool }) {
+		if !value.IsValid() {
+			report(field, value, "not a valid value")
+		}
+	}
+	valid("AdvanceMedia", h.AdvanceMedia)
+	valid("CutMedia", h.CutMedia)
+	valid("Jog", h.Jog)
+	valid("LeadingEdge", h.LeadingEdge)
+	valid("Orientation", h.Orientation)
+	const maxDPI = 1 << 16
+	if h.HorizDPI <= 0 || h.HorizDPI > maxDPI {
+		report("HorizDPI", h.HorizDPI, "implausible resolution")
+	}
+	if h.VertDPI <= 0 || h.VertDPI > maxDPI {
+		report("VertDPI", h.VertDPI, "implausible resolution")
+	}
+	return errs
+}
+
+// isFormatError reports whether err is caused by the content of the
+// stream, as opposed to a failure of the underlying reader, and can
+// thus be recovered from by skipping data.
+func isFormatError(err error) bool {
+	return errors.Is(err, ErrInvalidFormat) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,243 @@
+package raster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestFillMissingLines(t *testing.T) {
+	for _, recover := range []bool{false, true} {
+		f := open("raster_truncated", t)
+		d, err := NewDecoderOptions(f, DecoderOptions{FillMissingLines: true, Recover: recover})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := d.NextPage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := p.UnreadLines()
+		b := make([]byte, p.LineSize())
+		for i := 0; i < lines; i++ {
+			for j := range b {
+				b[j] = 0xAA
+			}
+			if err := p.ReadLine(b); err != nil {
+				t.Fatalf("line %d: %v", i, err)
+			}
+		}
+		if !bytes.Equal(b, make([]byte, len(b))) {
+			t.Errorf("last line isn't white: %v", b)
+		}
+		// Line 236 is truncated.
+		if want := lines - 235; p.Synthesized() != want {
+			t.Errorf("synthesized %d lines, want %d", p.Synthesized(), want)
+		}
+		if !errors.Is(p.Err(), io.ErrUnexpectedEOF) {
+			t.Errorf("got page error %v, want io.ErrUnexpectedEOF", p.Err())
+		}
+		_, err = d.NextPage()
+		want := io.ErrUnexpectedEOF
+		if recover {
+			want = io.EOF
+		}
+		if !errors.Is(err, want) {
+			t.Errorf("recover = %t: NextPage returned %v, want %v", recover, err, want)
+		}
+		f.Close()
+	}
+}
+
+func TestRecover(t *testing.T) {
+	f := open("two_pages", t)
+	_, pages := decodeAll(f, t)
+	f.Close()
+
+	encode := func(p rawPage) []byte {
+		buf := &bytes.Buffer{}
+		encodeAll(buf, 2, binary.BigEndian, []rawPage{p}, t)
+		return buf.Bytes()[4:]
+	}
+	third := *pages[0].header
+	third.NumCopies = 3
+	a := encode(pages[0])
+	b := encode(pages[1])
+	c := encode(rawPage{&third, pages[0].lines})
+
+	// Damage the second page's header and put garbage between the
+	// second and third page.
+	b[4*64+34*4] = 0xFF // CUPS.BytesPerLine
+	garbage := make([]byte, 777)
+	rand.New(rand.NewSource(1)).Read(garbage)
+	var stream []byte
+	stream = append(stream, syncV2BE...)
+	stream = append(stream, a...)
+	stream = append(stream, b...)
+	stream = append(stream, garbage...)
+	stream = append(stream, c...)
+
+	if _, err := NewDecoder(bytes.NewReader(stream)); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDecoderOptions(bytes.NewReader(stream), DecoderOptions{Recover: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []*Header
+	var headerErrs int
+	for {
+		p, err := d.NextPage()
+		if err == io.EOF {
+			break
+		}
+		var herr *HeaderError
+		if errors.As(err, &herr) {
+			headerErrs++
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p.Header)
+		if _, err := p.ReadAllColors(make([]byte, p.LineSize())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if headerErrs != 1 {
+		t.Errorf("got %d header errors, want 1", headerErrs)
+	}
+	if len(got) != 2 || got[1].NumCopies != 3 {
+		t.Fatalf("recovered %d pages, want the first and the third page", len(got))
+	}
+	if want := int64(len(b) - headerSizeV2 + len(garbage)); d.Skipped() != want {
+		t.Errorf("skipped %d bytes, want %d", d.Skipped(), want)
+	}
+
+	// Errors for a header found by scanning are reported at the
+	// header.
+	d, err = NewDecoderOptions(bytes.NewReader(stream), DecoderOptions{Recover: true, MaxPages: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err := d.NextPage()
+		if err == io.EOF {
+			t.Fatal("no error for exceeding the page limit")
+		}
+		if errors.Is(err, ErrLimitExceeded) {
+			var derr *DecodeError
+			if !errors.As(err, &derr) {
+				t.Fatalf("got error %v, want a *DecodeError", err)
+			}
+			if want := int64(4 + len(a) + len(b) + len(garbage)); derr.Offset != want {
+				t.Errorf("got offset %d, want %d", derr.Offset, want)
+			}
+			break
+		}
+	}
+}
+
+// flakyReader returns data, then fails once with err, then returns
+// more data.
+type flakyReader struct {
+	chunks [][]byte
+	err    error
+}
+
+func (r *flakyReader) Read(b []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	if r.chunks[0] == nil {
+		r.chunks = r.chunks[1:]
+		return 0, r.err
+	}
+	n := copy(b, r.chunks[0])
+	r.chunks[0] = r.chunks[0][n:]
+	if len(r.chunks[0]) == 0 {
+		r.chunks = r.chunks[1:]
+	}
+	return n, nil
+}
+
+func TestRecoverReadError(t *testing.T) {
+	h := &Header{HorizDPI: 300, VertDPI: 300}
+	h.CUPS.Width = 8
+	h.CUPS.Height = 2
+	h.CUPS.BitsPerColor = 8
+	h.CUPS.BitsPerPixel = 8
+	h.CUPS.BytesPerLine = 8
+	lines := [][]byte{make([]byte, 8), make([]byte, 8)}
+	// encode encodes the n-th page, which is identified by its number
+	// of copies.
+	encode := func(h Header, n int) []byte {
+		h.NumCopies = n
+		buf := &bytes.Buffer{}
+		encodeAll(buf, 3, binary.BigEndian, []rawPage{{&h, lines}}, t)
+		return buf.Bytes()[4:]
+	}
+	// The third page has to be found by scanning. It uses 1-bit
+	// KCMYcm, which stores 6 colors in 8 bits per pixel.
+	kcmycm := *h
+	kcmycm.CUPS.ColorSpace = ColorSpaceKCMYcm
+	kcmycm.CUPS.BitsPerColor = 1
+	bad := encode(*h, 2)
+	bad[4*64+34*4] = 0xFF // CUPS.BytesPerLine
+	pages := [][]byte{encode(*h, 1), bad, encode(kcmycm, 3), encode(*h, 4), encode(*h, 5), encode(*h, 6), encode(*h, 7)}
+	var stream []byte
+	var offsets []int
+	stream = append(stream, syncV3BE...)
+	for _, p := range pages {
+		offsets = append(offsets, len(stream))
+		stream = append(stream, p...)
+	}
+
+	// Read errors occur in the middle of the third page's header,
+	// while scanning for it, and in the middle of the fourth page's
+	// header, which is decoded normally. Decoding resumes with the
+	// next header in both cases. A third one occurs in the image data
+	// of the fifth page, while it is discarded; scanning then resumes
+	// after its first line.
+	errReset := errors.New("connection reset")
+	split1 := offsets[3] - 100
+	split2 := offsets[3] + 100
+	split3 := offsets[5] - 8
+	r := &flakyReader{
+		chunks: [][]byte{stream[:split1], nil, stream[split1:split2], nil, stream[split2:split3], nil, stream[split3:]},
+		err:    errReset,
+	}
+	d, err := NewDecoderOptions(r, DecoderOptions{Recover: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	var errs []error
+	for {
+		p, err := d.NextPage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		got = append(got, p.Header.NumCopies)
+	}
+	var herr *HeaderError
+	if len(errs) != 4 || !errors.As(errs[0], &herr) || !errors.Is(errs[1], errReset) || !errors.Is(errs[2], errReset) || !errors.Is(errs[3], errReset) {
+		t.Errorf("got errors %v, want a header error and %v three times", errs, errReset)
+	}
+	if want := []int{1, 3, 5, 6, 7}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("recovered pages %v, want %v", got, want)
+	}
+	// The image data of the damaged page, what was left of the fourth
+	// page after the read error, and the last line of the fifth page.
+	if want := int64(16 + len(pages[3]) - 100 + 8); d.Skipped() != want {
+		t.Errorf("skipped %d bytes, want %d", d.Skipped(), want)
+	}
+}
@@ -0,0 +1,128 @@
+package raster
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Apple's URF ("UNIRAST") format, as sent by AirPrint clients, uses
+// its own file and page headers, but compresses lines nearly the
+// same way as version 2 CUPS raster streams. The decoder maps its
+// page headers to Header so that URF streams can be consumed like
+// any other raster stream.
+
+const syncURF = "UNIRAST\x00"
+
+// versionURF is the internal version of URF streams. They are
+// always big-endian.
+const versionURF = -1
+
+const (
+	URFQualityDefault = 0
+	URFQualityDraft   = 3
+	URFQualityNormal  = 4
+	URFQualityHigh    = 5
+)
+
+var urfColorSpaces = []ColorSpace{
+	ColorSpacesGray,
+	ColorSpacesRGB,
+	ColorSpaceCIELab,
+	ColorSpaceAdobeRGB,
+	ColorSpaceGray,
+	ColorSpaceRGB,
+	ColorSpaceCMYK,
+}
+
+var urfMediaTypes = []string{
+	"auto",
+	"stationery",
+	"transparency",
+	"envelope",
+	"cardstock",
+	"labels",
+	"stationery-letterhead",
+	"disc",
+	"photographic-matte",
+	"photographic-satin",
+	"photographic-semi-gloss",
+	"photographic-glossy",
+	"photographic-high-gloss",
+	"other",
+}
+
+// initURF reads the remainder of the URF file header, after the
+// first four bytes of the sync word have been consumed.
+func (d *Decoder) initURF(r io.Reader) error {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if string(b[:4]) != syncURF[4:] {
+		return ErrUnknownVersion
+	}
+	d.version = versionURF
+	d.bo = binary.BigEndian
+	d.flavor = FlavorURF
+	d.urfPages = int(binary.BigEndian.Uint32(b[4:]))
+	return nil
+}
+
+// decodeURFHeader decodes a 32 byte URF page header. The header
+// only stores a subset of the information of a CUPS raster header;
+// the mapping follows the one used by CUPS itself.
+func (d *Decoder) decodeURFHeader() (*Header, error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, err
+	}
+	bpp := int(b[0])
+	if int(b[1]) >= len(urfColorSpaces) {
+		return nil, ErrInvalidFormat
+	}
+	cs := urfColorSpaces[b[1]]
+	if bpp == 0 || bpp%(8*cs.NumColors()) != 0 {
+		return nil, ErrInvalidFormat
+	}
+	res := int(binary.BigEndian.Uint32(b[20:]))
+
+	h := &Header{}
+	h.MediaType = "other"
+	if int(b[4]) < len(urfMediaTypes) {
+		h.MediaType = urfMediaTypes[b[4]]
+	}
+	h.MediaPosition = int(b[5])
+	h.HorizDPI = res
+	h.VertDPI = res
+	h.NumCopies = 1
+	if b[2] >= 2 {
+		h.Duplex = true
+		h.Tumble = b[2] == 2
+	}
+	h.CUPS.Width = int(binary.BigEndian.Uint32(b[12:]))
+	h.CUPS.Height = int(binary.BigEndian.Uint32(b[16:]))
+	h.CUPS.BitsPerPixel = bpp
+	h.CUPS.BitsPerColor = bpp / cs.NumColors()
+	h.CUPS.BytesPerLine = h.CUPS.Width * bpp / 8
+	h.CUPS.ColorOrder = ChunkyPixels
+	h.CUPS.ColorSpace = cs
+	h.CUPS.NumColors = cs.NumColors()
+	if res > 0 {
+		h.Width = h.CUPS.Width * 72 / res
+		h.Length = h.CUPS.Height * 72 / res
+		h.CUPS.PageSize[0] = float32(float64(h.CUPS.Width) * 72 / float64(res))
+		h.CUPS.PageSize[1] = float32(float64(h.CUPS.Height) * 72 / float64(res))
+	}
+	// SetPWG also sets MediaClass, like CUPS does for URF pages.
+	h.SetPWG(PWGHeader{
+		TotalPageCount:     d.urfPages,
+		CrossFeedTransform: 1,
+		FeedTransform:      1,
+		AlternatePrimary:   0xFFFFFF,
+		PrintQuality:       int(b[3]),
+	})
+	return h, nil
+}
@@ -0,0 +1,119 @@
+package raster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func urfPageHeader(bpp, cs, duplex, quality byte, width, height, res uint32) []byte {
+	b := make([]byte, 32)
+	b[0] = bpp
+	b[1] = cs
+	b[2] = duplex
+	b[3] = quality
+	b[4] = 11 // photographic-glossy
+	b[5] = 2
+	binary.BigEndian.PutUint32(b[12:], width)
+	binary.BigEndian.PutUint32(b[16:], height)
+	binary.BigEndian.PutUint32(b[20:], res)
+	return b
+}
+
+func TestDecodeURF(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("UNIRAST\x00")
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+
+	// Page 1: 4x3 sRGB, long-edge duplex, high quality
+	buf.Write(urfPageHeader(24, 1, 3, 5, 4, 3, 300))
+	// line 1, repeated once: one red pixel followed by white fill
+	buf.Write([]byte{1, 0, 255, 0, 0, 128})
+	// line 3: two literal pixels, two repeated ones
+	buf.Write([]byte{0, 255, 1, 2, 3, 4, 5, 6, 1, 7, 8, 9})
+
+	// Page 2: 2x1 CMYK, short-edge duplex
+	buf.Write(urfPageHeader(32, 6, 2, 4, 2, 1, 600))
+	buf.Write([]byte{0, 0, 1, 2, 3, 4, 128})
+
+	d, err := NewDecoder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Flavor() != FlavorURF {
+		t.Errorf("got flavor %d, want FlavorURF", d.Flavor())
+	}
+
+	p, err := d.NextPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := p.Header
+	if h.CUPS.ColorSpace != ColorSpacesRGB || h.CUPS.NumColors != 3 || h.CUPS.BitsPerColor != 8 {
+		t.Errorf("got color space %d with %d colors of %d bits, want sRGB with 3 colors of 8 bits",
+			h.CUPS.ColorSpace, h.CUPS.NumColors, h.CUPS.BitsPerColor)
+	}
+	if h.CUPS.BytesPerLine != 12 || h.HorizDPI != 300 || h.VertDPI != 300 {
+		t.Errorf("got %d bytes per line at %dx%d DPI, want 12 at 300x300", h.CUPS.BytesPerLine, h.HorizDPI, h.VertDPI)
+	}
+	if !h.Duplex || h.Tumble {
+		t.Errorf("got Duplex = %t, Tumble = %t, want true, false", h.Duplex, h.Tumble)
+	}
+	if h.MediaType != "photographic-glossy" || h.MediaPosition != 2 {
+		t.Errorf("got media type %q in position %d, want photographic-glossy in position 2", h.MediaType, h.MediaPosition)
+	}
+	if pwg := h.PWG(); pwg.PrintQuality != URFQualityHigh || pwg.TotalPageCount != 2 {
+		t.Errorf("got quality %d and %d pages, want %d and 2", pwg.PrintQuality, pwg.TotalPageCount, URFQualityHigh)
+	}
+
+	want := [][]byte{
+		{255, 0, 0, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		{255, 0, 0, 255, 255, 255, 255, 255, 255, 255, 255, 255},
+		{1, 2, 3, 4, 5, 6, 7, 8, 9, 7, 8, 9},
+	}
+	b := make([]byte, p.LineSize())
+	for i, w := range want {
+		if err := p.ReadLine(b); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, w) {
+			t.Errorf("line %d: got %v, want %v", i, b, w)
+		}
+	}
+
+	p, err = d.NextPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Header.Duplex || !p.Header.Tumble {
+		t.Errorf("got Duplex = %t, Tumble = %t, want true, true", p.Header.Duplex, p.Header.Tumble)
+	}
+	b = make([]byte, p.LineSize())
+	if err := p.ReadLine(b); err != nil {
+		t.Fatal(err)
+	}
+	if w := []byte{1, 2, 3, 4, 0, 0, 0, 0}; !bytes.Equal(b, w) {
+		t.Errorf("got %v, want %v", b, w)
+	}
+	colors, err := p.ParseColors(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(colors) != 2 {
+		t.Errorf("got %d colors, want 2", len(colors))
+	}
+
+	if _, err := d.NextPage(); err != io.EOF {
+		t.Errorf("got %v after last page, want io.EOF", err)
+	}
+}
+
+func TestDecodeURFTruncated(t *testing.T) {
+	if _, err := NewDecoder(bytes.NewReader([]byte("UNIRAST\x00\x00"))); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v for truncated file header, want io.ErrUnexpectedEOF", err)
+	}
+	if _, err := NewDecoder(bytes.NewReader([]byte("UNIRAZZ\x00\x00\x00\x00\x01"))); err != ErrUnknownVersion {
+		t.Errorf("got %v for bad sync word, want ErrUnknownVersion", err)
+	}
+}